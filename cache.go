@@ -7,10 +7,15 @@ import (
 	"time"
 
 	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type rClient struct {
 	rdb redis.UniversalClient
+
+	// tracer creates the spans of the commands if Config.TracerProvider is set
+	tracer trace.Tracer
 }
 
 func newRClient(rdb redis.UniversalClient) *rClient {
@@ -19,7 +24,10 @@ func newRClient(rdb redis.UniversalClient) *rClient {
 	}
 }
 
-func (c *rClient) Get(ctx context.Context, key string, value any) error {
+func (c *rClient) Get(ctx context.Context, key string, value any) (err error) {
+	ctx, end := c.span(ctx, "GET")
+	defer func() { end(err) }()
+
 	v, err := c.rdb.Get(ctx, key).Bytes()
 	if err != nil {
 		return fmt.Errorf("rClient.Get: %w", err)
@@ -32,7 +40,10 @@ func (c *rClient) Get(ctx context.Context, key string, value any) error {
 	return nil
 }
 
-func (c *rClient) Set(ctx context.Context, key string, value any, ttl time.Duration) error {
+func (c *rClient) Set(ctx context.Context, key string, value any, ttl time.Duration) (err error) {
+	ctx, end := c.span(ctx, "SET")
+	defer func() { end(err) }()
+
 	v, err := json.Marshal(value)
 	if err != nil {
 		return fmt.Errorf("rClient.Set: %w", err)
@@ -44,3 +55,28 @@ func (c *rClient) Set(ctx context.Context, key string, value any, ttl time.Durat
 
 	return nil
 }
+
+func (c *rClient) Del(ctx context.Context, key string) (err error) {
+	ctx, end := c.span(ctx, "DEL")
+	defer func() { end(err) }()
+
+	if err := c.rdb.Del(ctx, key).Err(); err != nil {
+		return fmt.Errorf("rClient.Del: %w", err)
+	}
+
+	return nil
+}
+
+// span starts the span of the command, the returned function ends it
+func (c *rClient) span(ctx context.Context, cmd string) (context.Context, func(err error)) {
+	if c.tracer == nil {
+		return ctx, func(error) {}
+	}
+
+	ctx, span := c.tracer.Start(ctx, "fsm.cache "+cmd,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", "redis")),
+	)
+
+	return ctx, func(err error) { endSpan(span, err) }
+}
@@ -1,9 +1,17 @@
 package event_fsm
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
+	"slices"
 	"time"
 
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
@@ -14,22 +22,101 @@ type Config[T comparable] struct {
 	// StateDetector is the state detector instance, required
 	StateDetector *StateDetector[T]
 
-	// DBConf is the database connection string, required
+	// ValidationWarnings are the checks of StateDetector.Validate which are logged as warnings
+	// instead of failing NewFSM, optional. Every problem of the state graph fails NewFSM by default.
+	// The warnings of the definition the StateDetector is loaded from are added, see Definition.ValidationWarnings.
+	ValidationWarnings []ValidationCheck
+
+	// Store is the storage of events and logs, optional.
+	// If not set, the Postgres storage with Redis cache is created from the fields below.
+	Store Store
+
+	// Locker serializes processing of the events of one target, optional.
+	// If not set, RedisLocker is used with the default storage and MemoryLocker with the custom Store.
+	Locker Locker
+
+	// LockMode is the behaviour of ProcessEvent when the target is busy, LockModeWait by default
+	LockMode LockMode
+
+	// VersionedTargets keeps the state and the version of every target in the Store,
+	// which must implement TargetStore. The version is compared and swapped on every transition,
+	// ProcessEvent returns ErrConcurrentModification if the target was changed by another worker.
+	VersionedTargets bool
+
+	// ManagedState keeps the current states of the targets in the Store, which must implement TargetStore,
+	// instead of TargetData.GetState and TargetData.Save, see NewManagedTargetData and FSM.CurrentState.
+	// The targets are versioned as with VersionedTargets.
+	ManagedState bool
+
+	// Transactional runs every step of processing, the state and the transition to the next one,
+	// in one database transaction, the Store must implement TxStore. The transaction is passed
+	// to TargetData.Save and the executors in the context, see TxFromContext.
+	// Every attempt retried by RetryPolicy runs in its own transaction, the writes of the failed attempts
+	// are rolled back and the backoff doesn't hold the transaction.
+	Transactional bool
+
+	// Outbox writes the outbox entry with the log of every state the target enters, the Store must implement
+	// OutboxStore. The entry is written in the same transaction as the log if the Store implements TxStore.
+	// The entries are delivered by OutboxRelay.
+	Outbox bool
+
+	// DeadLetters records the events which failed to process in the Store, which must implement DeadLetterStore,
+	// see FSM.DeadLetters and FSM.ReplayDeadLetter
+	DeadLetters bool
+
+	// Queue keeps the events of FSM.Enqueue processed by Worker, optional.
+	// If not set, the Store is used if it implements QueueStore, see NewRedisStreamQueue for the alternative.
+	Queue QueueStore
+
+	// TracerProvider creates the OpenTelemetry spans of processing, optional. Every processed event has a span
+	// with the spans of the executed states, the IDs of the state span are saved in the log, see Log.TraceID.
+	// The default storage creates the spans of the queries.
+	TracerProvider trace.TracerProvider
+
+	// IdempotencyRetention is the time the idempotency keys of the events are kept, 7 days by default,
+	// see Target.SetIdempotencyKey
+	IdempotencyRetention time.Duration
+
+	// DB is the existing database pool, optional.
+	// If one of DB, SQLDB or PgxPool is set, it is used instead of connecting with DBConf.
+	DB *sqlx.DB
+
+	// SQLDB is the existing database pool, optional, see DB
+	SQLDB *sql.DB
+
+	// PgxPool is the existing database pool, optional, see DB
+	PgxPool *pgxpool.Pool
+
+	// DBConf is the database connection string, required if Store and the database pool are not set
 	DBConf string
 
-	// RedisConf is the redis connection string, required
+	// RedisClient is the existing redis client (single node, cluster, sentinel or ring), optional.
+	// If set, it is used instead of connecting with RedisConf.
+	RedisClient redis.UniversalClient
+
+	// RedisConf is the redis connection string, required if Store and RedisClient are not set
 	RedisConf *Redis
 
-	// AppLabel is the application name to be used in the database connection, required
+	// AppLabel is the application name to be used in the database connection, required if Store is not set
 	AppLabel string
 
-	// MaxOpenConnections is the maximum number of open connections to the database, required
+	// Schema is the Postgres schema of the FSM tables, "fsm" by default
+	Schema string
+
+	// TablePrefix is prepended to the names of the FSM tables, optional
+	TablePrefix string
+
+	// TenantMode keeps the tables of each tenant in its own schema named "<Schema>_<tenant ID>",
+	// which is created and migrated on first use. The tenant is passed in the context with WithTenant.
+	TenantMode bool
+
+	// MaxOpenConnections is the maximum number of open connections to the database, required with DBConf
 	MaxOpenConnections int
 
-	// MaxIdleConnections is the maximum number of idle connections in the pool, required
+	// MaxIdleConnections is the maximum number of idle connections in the pool, required with DBConf
 	MaxIdleConnections int
 
-	// ConnectionMaxLifetime is the maximum amount of time a connection may be reused, required
+	// ConnectionMaxLifetime is the maximum amount of time a connection may be reused, required with DBConf
 	ConnectionMaxLifetime time.Duration
 }
 
@@ -38,6 +125,32 @@ type Redis struct {
 	Password string `env:"PASSWORD,default=qwerty"`
 }
 
+func (cfg *Config[T]) schema() Schema {
+	name := cfg.Schema
+	if name == "" {
+		name = defaultSchemaName
+	}
+
+	return Schema{
+		Name:        name,
+		TablePrefix: cfg.TablePrefix,
+	}
+}
+
+// dbPool returns the database pool set in the config, nil if it is not set
+func (cfg *Config[T]) dbPool() *sqlx.DB {
+	switch {
+	case cfg.DB != nil:
+		return cfg.DB
+	case cfg.SQLDB != nil:
+		return sqlx.NewDb(cfg.SQLDB, "pgx")
+	case cfg.PgxPool != nil:
+		return sqlx.NewDb(stdlib.OpenDBFromPool(cfg.PgxPool), "pgx")
+	default:
+		return nil
+	}
+}
+
 func (cfg *Config[T]) check() error {
 	if cfg.Logger == nil {
 		return fmt.Errorf("Config.Logger is not set")
@@ -51,14 +164,67 @@ func (cfg *Config[T]) check() error {
 		return fmt.Errorf("Config.StateDetector.getMainState() failed: %w", err)
 	}
 
-	if cfg.DBConf == "" {
-		return fmt.Errorf("Config.DBConf is not set")
+	if err := cfg.StateDetector.Validate(); err != nil {
+		var errs ValidationErrors
+		if !errors.As(err, &errs) {
+			return fmt.Errorf("Config.StateDetector.Validate() failed: %w", err)
+		}
+
+		checks := append(slices.Clone(cfg.ValidationWarnings), cfg.StateDetector.validationWarnings...)
+
+		warnings, failed := errs.classify(checks)
+		for _, w := range warnings {
+			cfg.Logger.Warn("state graph validation", zap.Error(w))
+		}
+
+		if len(failed) > 0 {
+			return fmt.Errorf("Config.StateDetector.Validate() failed: %w", failed)
+		}
+	}
+
+	if cfg.Store != nil {
+		return nil
 	}
 
 	if cfg.AppLabel == "" {
 		return fmt.Errorf("Config.AppLabel is not set")
 	}
 
+	if err := cfg.checkDB(); err != nil {
+		return err
+	}
+
+	if cfg.RedisClient == nil && cfg.RedisConf == nil {
+		return fmt.Errorf("Config.RedisConf is not set")
+	}
+
+	return nil
+}
+
+func (cfg *Config[T]) checkDB() error {
+	pools := 0
+	if cfg.DB != nil {
+		pools++
+	}
+	if cfg.SQLDB != nil {
+		pools++
+	}
+	if cfg.PgxPool != nil {
+		pools++
+	}
+
+	if pools > 1 {
+		return fmt.Errorf("only one of Config.DB, Config.SQLDB and Config.PgxPool can be set")
+	}
+
+	if pools == 1 {
+		return nil
+	}
+
+	if cfg.DBConf == "" {
+		return fmt.Errorf("Config.DBConf is not set")
+	}
+
 	if cfg.MaxOpenConnections == 0 {
 		return fmt.Errorf("Config.MaxOpenConnections is not set")
 	}
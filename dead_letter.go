@@ -0,0 +1,292 @@
+package event_fsm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DeadLetter is the event which could not be processed: the state failed with ResultStatusFail,
+// its executor failed after the retries or the state has no transition for its result status,
+// or the Scheduler could not deliver the event within its attempts.
+// The dead letters are recorded if Config.DeadLetters is set.
+type DeadLetter struct {
+	ID       string
+	TargetID string
+	EventID  string
+
+	// State is the state the event failed in or the state the scheduled event is bound to, optional
+	State StateName
+
+	// Status is the result status of the state
+	Status ResultStatus
+
+	// ResumeStatus is the status of the scheduled event applied to the state instead of its execution on replay,
+	// see ScheduledEvent.Status
+	ResumeStatus ResultStatus
+
+	// Error is the error of the processing, with the stack if the executor panicked
+	Error string
+
+	// Attempts is the number of the executions of the state
+	Attempts int
+
+	// Payload is the payload of the event, it is used on replay
+	Payload EventPayload
+
+	CreatedAt time.Time
+}
+
+type deadLetterDto struct {
+	ID           string          `db:"id"`
+	TargetID     string          `db:"target_id"`
+	EventID      string          `db:"event_id"`
+	State        string          `db:"state"`
+	ResultStatus string          `db:"result_status"`
+	ResumeStatus string          `db:"resume_status"`
+	Error        string          `db:"error"`
+	Attempts     int             `db:"attempts"`
+	Payload      json.RawMessage `db:"payload"`
+	CreatedAt    time.Time       `db:"created_at"`
+}
+
+func (d *deadLetterDto) toDeadLetter() (DeadLetter, error) {
+	dl := DeadLetter{
+		ID:           d.ID,
+		TargetID:     d.TargetID,
+		EventID:      d.EventID,
+		State:        StateName(d.State),
+		Status:       ResultStatus(d.ResultStatus),
+		ResumeStatus: ResultStatus(d.ResumeStatus),
+		Error:        d.Error,
+		Attempts:     d.Attempts,
+		CreatedAt:    d.CreatedAt,
+	}
+
+	if len(d.Payload) > 0 {
+		if err := json.Unmarshal(d.Payload, &dl.Payload); err != nil {
+			return DeadLetter{}, fmt.Errorf("json.Unmarshal of payload of dead letter %s: %w", d.ID, err)
+		}
+	}
+
+	return dl, nil
+}
+
+func deadLetterToDTO(dl DeadLetter) (deadLetterDto, error) {
+	dto := deadLetterDto{
+		ID:           dl.ID,
+		TargetID:     dl.TargetID,
+		EventID:      dl.EventID,
+		State:        dl.State.String(),
+		ResultStatus: dl.Status.String(),
+		ResumeStatus: dl.ResumeStatus.String(),
+		Error:        dl.Error,
+		Attempts:     dl.Attempts,
+		CreatedAt:    dl.CreatedAt,
+	}
+
+	if !dl.Payload.IsEmpty() {
+		payload, err := json.Marshal(dl.Payload)
+		if err != nil {
+			return deadLetterDto{}, fmt.Errorf("json.Marshal of payload: %w", err)
+		}
+
+		dto.Payload = payload
+	}
+
+	return dto, nil
+}
+
+// DeadLetterFilter narrows down the dead letters returned by FSM.DeadLetters
+type DeadLetterFilter struct {
+	// TargetID returns only the dead letters of the target, optional
+	TargetID string
+
+	// From is the lower bound of the dead letter creation time, inclusive, optional
+	From time.Time
+
+	// To is the upper bound of the dead letter creation time, exclusive, optional
+	To time.Time
+
+	// Cursor is the DeadLetterPage.NextCursor of the previous page, optional
+	Cursor string
+
+	// Limit is the maximum number of dead letters in the page, 100 by default
+	Limit int
+}
+
+// DeadLetterPage is a page of the dead letters, newest first
+type DeadLetterPage struct {
+	DeadLetters []DeadLetter
+
+	// NextCursor is empty if there are no more dead letters
+	NextCursor string
+}
+
+// DeadLetterStore is implemented by the stores which can keep the dead letters
+type DeadLetterStore interface {
+	// SaveDeadLetter saves the dead letter and returns its ID
+	SaveDeadLetter(ctx context.Context, dl DeadLetter) (string, error)
+
+	// GetDeadLetter returns the dead letter by its ID, ErrDeadLetterNotFound if there is none
+	GetDeadLetter(ctx context.Context, id string) (DeadLetter, error)
+
+	// GetDeadLetters returns the page of the dead letters, newest first
+	GetDeadLetters(ctx context.Context, filter DeadLetterFilter) (DeadLetterPage, error)
+
+	// DeleteDeadLetter deletes the dead letter, the missing dead letter is not an error
+	DeleteDeadLetter(ctx context.Context, id string) error
+}
+
+// DeadLetters returns the dead letters, newest first
+func (f *FSM[T]) DeadLetters(ctx context.Context, filter DeadLetterFilter) (DeadLetterPage, error) {
+	store, err := f.deadLetterStore()
+	if err != nil {
+		return DeadLetterPage{}, err
+	}
+
+	if _, _, err = decodeCursor(filter.Cursor); err != nil {
+		return DeadLetterPage{}, err
+	}
+
+	filter.Limit = historyLimit(filter.Limit)
+
+	page, err := store.GetDeadLetters(ctx, filter)
+	if err != nil {
+		return DeadLetterPage{}, fmt.Errorf("store.GetDeadLetters: %w", err)
+	}
+
+	return page, nil
+}
+
+// DeadLetter returns the dead letter by its ID
+func (f *FSM[T]) DeadLetter(ctx context.Context, id string) (DeadLetter, error) {
+	store, err := f.deadLetterStore()
+	if err != nil {
+		return DeadLetter{}, err
+	}
+
+	dl, err := store.GetDeadLetter(ctx, id)
+	if err != nil {
+		return DeadLetter{}, fmt.Errorf("store.GetDeadLetter: %w", err)
+	}
+
+	return dl, nil
+}
+
+// DiscardDeadLetter deletes the dead letter without processing its event
+func (f *FSM[T]) DiscardDeadLetter(ctx context.Context, id string) error {
+	store, err := f.deadLetterStore()
+	if err != nil {
+		return err
+	}
+
+	if err = store.DeleteDeadLetter(ctx, id); err != nil {
+		return fmt.Errorf("store.DeleteDeadLetter: %w", err)
+	}
+
+	return nil
+}
+
+// ReplayDeadLetter processes the payload of the dead letter again as the new event of the target,
+// starting from the state the event failed in, or from the given state if it is not empty.
+// The dead letter without the state is processed from the current state of the target.
+// The resume status of the dead letter is applied to the state instead of its execution.
+// data is the current data of the target of the dead letter.
+//
+// The dead letter is deleted when the event is processed or is dead-lettered again,
+// in the latter case the new dead letter is recorded.
+func (f *FSM[T]) ReplayDeadLetter(ctx context.Context, id string, data TargetData[T], from StateName) (Target[T], error) {
+	t := NewTarget(data)
+
+	store, err := f.deadLetterStore()
+	if err != nil {
+		return t, err
+	}
+
+	dl, err := store.GetDeadLetter(ctx, id)
+	if err != nil {
+		return t, fmt.Errorf("store.GetDeadLetter: %w", err)
+	}
+
+	if data.IsNull() || data.ID() != dl.TargetID {
+		return t, fmt.Errorf("dead letter %s is not of the target", id)
+	}
+
+	if from == "" {
+		from = dl.State
+	}
+
+	if from != "" {
+		if _, err = f.stateDetector.stateByName(from); err != nil {
+			return t, fmt.Errorf("%w: %s", ErrStateNotFound, from)
+		}
+	}
+
+	t.payload = dl.Payload
+	t.startState = from
+	t.resumeStatus = dl.ResumeStatus
+
+	// the replay waits for the target regardless of Config.LockMode
+	nt, err := f.lockAndProcess(ctx, t, true)
+	if err != nil && !isDeadLetter(err) {
+		return nt, err
+	}
+
+	if delErr := store.DeleteDeadLetter(ctx, id); delErr != nil {
+		return nt, errors.Join(err, fmt.Errorf("store.DeleteDeadLetter: %w", delErr))
+	}
+
+	return nt, err
+}
+
+func (f *FSM[T]) deadLetterStore() (DeadLetterStore, error) {
+	store, ok := f.store.(DeadLetterStore)
+	if !ok {
+		return nil, fmt.Errorf("dead letters: %w", ErrNotSupported)
+	}
+
+	return store, nil
+}
+
+// isDeadLetter is true if the error of processing makes the event dead-lettered
+func isDeadLetter(err error) bool {
+	var execErr *ExecutionError
+
+	return errors.As(err, &execErr) || errors.Is(err, ErrStateFailed) || errors.Is(err, ErrNoNextState)
+}
+
+// saveDeadLetter records the event of the target if processing failed with the dead-letter error.
+// The failure to record is logged, it doesn't replace the error of processing.
+func (f *FSM[T]) saveDeadLetter(ctx context.Context, t Target[T], err error) {
+	if f.deadLetters == nil || !isDeadLetter(err) {
+		return
+	}
+
+	dl := DeadLetter{
+		TargetID: t.ID(),
+		EventID:  t.eventID,
+		Status:   t.stateResult,
+		Error:    err.Error(),
+		Attempts: 1,
+		Payload:  t.payload,
+	}
+
+	if t.state != nil {
+		dl.State = t.state.Name
+	}
+
+	var execErr *ExecutionError
+	if errors.As(err, &execErr) {
+		dl.State, dl.Error, dl.Attempts = execErr.State, errorText(execErr.Err), execErr.Attempt
+	}
+
+	if _, saveErr := f.deadLetters.SaveDeadLetter(context.WithoutCancel(ctx), dl); saveErr != nil {
+		f.l.Error("error saving dead letter", zap.Error(saveErr), zap.NamedError("cause", err),
+			zap.String("target_id", dl.TargetID), zap.String("event_id", dl.EventID))
+	}
+}
@@ -0,0 +1,97 @@
+package event_fsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestDeadLetters(t *testing.T) {
+	ctx := context.Background()
+
+	sd := NewStateDetector[*eventData]()
+	first := sd.NewState(StateFirstCheck, &stateFlaky{failures: 3}, StateTypeTransition)
+	add := sd.NewState(StateAdd3, &stateFlaky{}, StateTypeTransition)
+	printResult := sd.NewState(StatePrintResult, &stateFlaky{}, StateTypeTransition)
+	printResult.SetTerminal()
+	first.SetNext(add, ResultStatusOk)
+	add.SetNext(printResult, ResultStatusOk)
+	sd.SetMainState(StateFirstCheck)
+
+	fsm, err := NewFSM(&Config[*eventData]{Logger: zap.NewNop(), StateDetector: sd, Store: NewMemoryStore(), DeadLetters: true})
+	if err != nil {
+		t.Fatalf("NewFSM: %v", err)
+	}
+
+	ed1, ed2, ed3 := newEventData(1), newEventData(2), newEventData(3)
+	for _, ed := range []*eventData{&ed1, &ed2, &ed3} {
+		var execErr *ExecutionError
+		if _, err = fsm.ProcessEventWithPayload(ctx, NewTarget(ed), EventPayload{Name: "start"}); !errors.As(err, &execErr) {
+			t.Fatalf("expected ExecutionError, got %v", err)
+		}
+	}
+
+	page, err := fsm.DeadLetters(ctx, DeadLetterFilter{TargetID: ed1.ID()})
+	if err != nil || len(page.DeadLetters) != 1 {
+		t.Fatalf("expected 1 dead letter of the target, got %+v, %v", page.DeadLetters, err)
+	}
+
+	dl, err := fsm.DeadLetter(ctx, page.DeadLetters[0].ID)
+	if err != nil {
+		t.Fatalf("DeadLetter: %v", err)
+	}
+
+	if dl.State != StateFirstCheck || dl.Status != ResultStatusFail || dl.Error != "temporary error" ||
+		dl.Attempts != 1 || dl.Payload.Name != "start" || dl.EventID == "" {
+		t.Fatalf("unexpected dead letter %+v", dl)
+	}
+
+	// the state runs again and succeeds
+	if _, err = fsm.ReplayDeadLetter(ctx, dl.ID, &ed1, ""); err != nil {
+		t.Fatalf("ReplayDeadLetter: %v", err)
+	}
+
+	if ed1.GetState() != StatePrintResult {
+		t.Fatalf("expected state %s, got %s", StatePrintResult, ed1.GetState())
+	}
+
+	if _, err = fsm.DeadLetter(ctx, dl.ID); !errors.Is(err, ErrDeadLetterNotFound) {
+		t.Fatalf("expected the replayed dead letter deleted, got %v", err)
+	}
+
+	page, err = fsm.DeadLetters(ctx, DeadLetterFilter{TargetID: ed2.ID()})
+	if err != nil || len(page.DeadLetters) != 1 {
+		t.Fatalf("expected 1 dead letter of the target, got %+v, %v", page.DeadLetters, err)
+	}
+
+	// the failed state is skipped
+	if _, err = fsm.ReplayDeadLetter(ctx, page.DeadLetters[0].ID, &ed2, StateAdd3); err != nil {
+		t.Fatalf("ReplayDeadLetter: %v", err)
+	}
+
+	history, _ := fsm.History(ctx, ed2.ID(), HistoryFilter{States: []StateName{StateAdd3}})
+	if ed2.GetState() != StatePrintResult || len(history.Logs) != 1 {
+		t.Fatalf("expected state %s through %s, got %s, %d logs", StatePrintResult, StateAdd3, ed2.GetState(), len(history.Logs))
+	}
+
+	page, err = fsm.DeadLetters(ctx, DeadLetterFilter{})
+	if err != nil || len(page.DeadLetters) != 1 || page.DeadLetters[0].TargetID != ed3.ID() {
+		t.Fatalf("expected 1 dead letter of the third target, got %+v, %v", page.DeadLetters, err)
+	}
+
+	if err = fsm.DiscardDeadLetter(ctx, page.DeadLetters[0].ID); err != nil {
+		t.Fatalf("DiscardDeadLetter: %v", err)
+	}
+
+	if _, err = fsm.ReplayDeadLetter(ctx, page.DeadLetters[0].ID, &ed3, ""); !errors.Is(err, ErrDeadLetterNotFound) {
+		t.Fatalf("expected ErrDeadLetterNotFound, got %v", err)
+	}
+
+	// the corrupt payload is not replayed as empty
+	dto := deadLetterDto{ID: "1", Payload: []byte(`{"name":`)}
+	if _, err = dto.toDeadLetter(); err == nil {
+		t.Fatal("expected the error of the corrupt payload")
+	}
+}
@@ -0,0 +1,248 @@
+package event_fsm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	definitionStateTypeTransition = "transition"
+	definitionStateTypeWaitEvent  = "wait_event"
+)
+
+// Definition is the declarative description of the state graph, see LoadStateDetector
+//
+//	main_state: FirstCheck
+//	validation_warnings: [transition_cycle]
+//	states:
+//	  - name: FirstCheck
+//	    type: transition
+//	    next:
+//	      ok: ManualAdd
+//	      not_enough: Add3
+//	  - name: Add3
+//	    type: transition
+//	    next:
+//	      ok: FirstCheck
+//	  - name: ManualAdd
+//	    type: wait_event
+//	    executor: manual_add
+//	    timeout:
+//	      after: 48h
+//	      status: timeout
+//	    next:
+//	      timeout: Reminder
+//	  - name: Reminder
+//	    type: transition
+//	    terminal: true
+type Definition struct {
+	// MainState is the name of the state new targets start from
+	MainState string `json:"main_state" yaml:"main_state"`
+
+	// ValidationWarnings are the checks of StateDetector.Validate which don't fail loading and NewFSM,
+	// written in snake case, e.g. transition_cycle
+	ValidationWarnings []string `json:"validation_warnings,omitempty" yaml:"validation_warnings,omitempty"`
+
+	States []StateDefinition `json:"states" yaml:"states"`
+}
+
+// StateDefinition is the declarative description of the state
+type StateDefinition struct {
+	Name string `json:"name" yaml:"name"`
+
+	// Type is either "transition" or "wait_event"
+	Type string `json:"type" yaml:"type"`
+
+	// Executor is the name of the executor in ExecutorRegistry, the state name by default
+	Executor string `json:"executor,omitempty" yaml:"executor,omitempty"`
+
+	Terminal bool `json:"terminal,omitempty" yaml:"terminal,omitempty"`
+
+	// Next maps the result statuses to the names of the next states
+	Next map[string]string `json:"next,omitempty" yaml:"next,omitempty"`
+
+	Timeout *TimeoutDefinition `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+}
+
+// TimeoutDefinition is the declarative description of the state timeout, see State.SetTimeout
+type TimeoutDefinition struct {
+	// After is the duration in the time.ParseDuration format, e.g. 48h
+	After string `json:"after" yaml:"after"`
+
+	// Status is the result status applied when the time is out
+	Status string `json:"status" yaml:"status"`
+}
+
+// ExecutorRegistry binds the executor names used in Definition to the executors
+type ExecutorRegistry[T comparable] struct {
+	executors map[string]Executor[T]
+}
+
+func NewExecutorRegistry[T comparable]() *ExecutorRegistry[T] {
+	return &ExecutorRegistry[T]{
+		executors: make(map[string]Executor[T]),
+	}
+}
+
+// Register binds the executor to the name
+func (r *ExecutorRegistry[T]) Register(name string, executor Executor[T]) {
+	r.executors[name] = executor
+}
+
+// RegisterContext binds the ContextExecutor to the name
+func (r *ExecutorRegistry[T]) RegisterContext(name string, executor ContextExecutor[T]) {
+	r.executors[name] = contextExecutor[T]{executor: executor}
+}
+
+// ParseDefinition parses the Definition from YAML or JSON document
+func ParseDefinition(r io.Reader) (*Definition, error) {
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+
+	var def Definition
+	if err := dec.Decode(&def); err != nil {
+		return nil, fmt.Errorf("decode definition: %w", err)
+	}
+
+	return &def, nil
+}
+
+// LoadStateDetector parses the Definition from YAML or JSON document and builds the state detector from it
+func LoadStateDetector[T comparable](data []byte, executors *ExecutorRegistry[T]) (*StateDetector[T], error) {
+	def, err := ParseDefinition(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	return NewStateDetectorFromDefinition(def, executors)
+}
+
+// NewStateDetectorFromDefinition builds the state detector from the Definition.
+// The state detector gets its own Registry with the state names and the result statuses of the definition.
+// All problems of the definition are reported at once, the built graph is checked with StateDetector.Validate.
+func NewStateDetectorFromDefinition[T comparable](def *Definition, executors *ExecutorRegistry[T]) (*StateDetector[T], error) {
+	var errs []error
+
+	warnings := make([]ValidationCheck, 0, len(def.ValidationWarnings))
+	for _, name := range def.ValidationWarnings {
+		check, ok := parseValidationCheck(name)
+		if !ok {
+			errs = append(errs, fmt.Errorf("unknown validation check %q", name))
+			continue
+		}
+
+		warnings = append(warnings, check)
+	}
+
+	sd := NewStateDetectorWithRegistry[T](NewRegistry())
+
+	for i, sdef := range def.States {
+		if sdef.Name == "" {
+			errs = append(errs, fmt.Errorf("state #%d: %w", i, ErrEmptyStateName))
+			continue
+		}
+
+		if _, ok := sd.states[sdef.Name]; ok {
+			errs = append(errs, fmt.Errorf("state %s: defined twice", sdef.Name))
+			continue
+		}
+
+		var stateType StateType
+		switch sdef.Type {
+		case definitionStateTypeTransition:
+			stateType = StateTypeTransition
+		case definitionStateTypeWaitEvent:
+			stateType = StateTypeWaitEvent
+		default:
+			errs = append(errs, fmt.Errorf("state %s: unknown type %q", sdef.Name, sdef.Type))
+			continue
+		}
+
+		executorName := sdef.Executor
+		if executorName == "" {
+			executorName = sdef.Name
+		}
+
+		executor, ok := executors.executors[executorName]
+		if !ok {
+			errs = append(errs, fmt.Errorf("state %s: executor %q is not registered", sdef.Name, executorName))
+			continue
+		}
+
+		state := sd.NewState(sd.registry.NewStateName(sdef.Name), executor, stateType)
+		state.Terminal = sdef.Terminal
+
+		if sdef.Timeout != nil {
+			after, err := time.ParseDuration(sdef.Timeout.After)
+			if err != nil || after <= 0 {
+				errs = append(errs, fmt.Errorf("state %s: invalid timeout %q", sdef.Name, sdef.Timeout.After))
+				continue
+			}
+
+			state.SetTimeout(after, sd.registry.NewResultStatus(sdef.Timeout.Status))
+		}
+	}
+
+	for _, sdef := range def.States {
+		state, ok := sd.states[sdef.Name]
+		if !ok {
+			continue
+		}
+
+		for _, status := range sortedKeys(sdef.Next) {
+			next, ok := sd.states[sdef.Next[status]]
+			if !ok {
+				errs = append(errs, fmt.Errorf(
+					"state %s: next state %s on %q is not defined", sdef.Name, sdef.Next[status], status,
+				))
+				continue
+			}
+
+			state.SetNext(next, sd.registry.NewResultStatus(status))
+		}
+	}
+
+	if def.MainState == "" {
+		errs = append(errs, ErrMainStateNotFound)
+	} else {
+		sd.SetMainState(sd.registry.NewStateName(def.MainState))
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid definition: %w", errors.Join(errs...))
+	}
+
+	sd.validationWarnings = warnings
+
+	if err := sd.Validate(); err != nil {
+		var verrs ValidationErrors
+		if !errors.As(err, &verrs) {
+			return nil, err
+		}
+
+		if _, failed := verrs.classify(warnings); len(failed) > 0 {
+			return nil, failed
+		}
+	}
+
+	return sd, nil
+}
+
+// parseValidationCheck parses the snake case name of the check, e.g. transition_cycle
+func parseValidationCheck(name string) (ValidationCheck, bool) {
+	for _, check := range []ValidationCheck{
+		CheckUnknownState, CheckUnreachableState, CheckTransitionCycle, CheckDeadEnd,
+	} {
+		if check.String() == strings.ReplaceAll(name, "_", " ") {
+			return check, true
+		}
+	}
+
+	return 0, false
+}
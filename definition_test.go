@@ -0,0 +1,80 @@
+package event_fsm
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+const testDefinition = `
+main_state: StateFirstCheck
+validation_warnings: [transition_cycle]
+states:
+  - name: StateFirstCheck
+    type: transition
+    next:
+      not_enough: StateAdd3
+      too_much: StateRemove2
+      ok: StateManualAdd
+  - name: StateAdd3
+    type: transition
+    next:
+      ok: StateFirstCheck
+  - name: StateRemove2
+    type: transition
+    next:
+      ok: StateFirstCheck
+  - name: StateManualAdd
+    type: wait_event
+    next:
+      ok: StateLastCheck
+  - name: StateLastCheck
+    type: transition
+    next:
+      wrong_number: StateFirstCheck
+      ok: StatePrintResult
+  - name: StatePrintResult
+    type: transition
+    executor: print
+    terminal: true
+`
+
+func TestLoadStateDetector(t *testing.T) {
+	executors := NewExecutorRegistry[*eventData]()
+	executors.Register(StateFirstCheck.String(), &stateFirstCheck{})
+	executors.Register(StateAdd3.String(), &stateAdd3{cache: newCache()})
+	executors.Register(StateRemove2.String(), &stateRemove2{cache: newCache()})
+	executors.Register(StateManualAdd.String(), &stateManualAdd{cache: newCache()})
+	executors.Register(StateLastCheck.String(), &stateLastCheck{})
+	executors.Register("print", &statePrintResult{t})
+
+	sd, err := LoadStateDetector([]byte(testDefinition), executors)
+	if err != nil {
+		t.Fatalf("LoadStateDetector: %v", err)
+	}
+
+	// the definition keeps the transition cycle a warning
+	fsm, err := NewFSM(&Config[*eventData]{Logger: zap.NewNop(), StateDetector: sd, Store: NewMemoryStore()})
+	if err != nil {
+		t.Fatalf("NewFSM: %v", err)
+	}
+
+	ed := newEventData(1)
+	target, err := fsm.ProcessEvent(context.Background(), NewTarget(&ed))
+	if err != nil {
+		t.Fatalf("ProcessEvent: %v", err)
+	}
+
+	if data, _ := target.Data(); data.StateName != StateManualAdd || data.Number != 10 {
+		t.Fatalf("expected %s with number 10, got %s with %d", StateManualAdd, data.StateName, data.Number)
+	}
+
+	broken := strings.Replace(testDefinition, "validation_warnings: [transition_cycle]\n", "", 1)
+	broken = strings.Replace(broken, "executor: print", "executor: unknown", 1)
+	if _, err = LoadStateDetector([]byte(broken), executors); err == nil ||
+		!strings.Contains(err.Error(), `executor "unknown" is not registered`) {
+		t.Fatalf("expected unknown executor error, got %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+package event_fsm
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// DOT renders the state graph in Graphviz DOT language.
+// The main state is pointed to by the start point, wait event states are filled,
+// terminal states have double border, the edges are labeled with the result statuses.
+func (sd *StateDetector[T]) DOT() string {
+	b := strings.Builder{}
+
+	b.WriteString("digraph fsm {\n")
+	b.WriteString("\trankdir=LR;\n")
+	b.WriteString("\tnode [shape=box, style=rounded];\n")
+
+	states := sd.diagramStates()
+
+	if main, err := sd.getMainState(); err == nil {
+		b.WriteString("\t__start [shape=point];\n")
+		fmt.Fprintf(&b, "\t__start -> %s;\n", dotQuote(main.String()))
+	}
+
+	for _, state := range states {
+		var attrs []string
+		if state.StateType == StateTypeWaitEvent {
+			attrs = append(attrs, `style="rounded,filled"`, `fillcolor="#fff3cd"`)
+		}
+
+		if state.Terminal {
+			attrs = append(attrs, "peripheries=2")
+		}
+
+		if len(attrs) == 0 {
+			fmt.Fprintf(&b, "\t%s;\n", dotQuote(state.Name.String()))
+		} else {
+			fmt.Fprintf(&b, "\t%s [%s];\n", dotQuote(state.Name.String()), strings.Join(attrs, ", "))
+		}
+	}
+
+	for _, state := range states {
+		for _, status := range sortedKeys(state.Next) {
+			fmt.Fprintf(&b, "\t%s -> %s [label=%s];\n",
+				dotQuote(state.Name.String()), dotQuote(state.Next[status].Name.String()), dotQuote(status),
+			)
+		}
+	}
+
+	b.WriteString("}\n")
+
+	return b.String()
+}
+
+// Mermaid renders the state graph as Mermaid state diagram.
+// The main state is the start of the diagram, wait event states have "waitEvent" class,
+// terminal states lead to the end, the transitions are labeled with the result statuses.
+func (sd *StateDetector[T]) Mermaid() string {
+	b := strings.Builder{}
+
+	b.WriteString("stateDiagram-v2\n")
+	b.WriteString("\tclassDef waitEvent fill:#fff3cd,stroke:#d39e00\n")
+
+	states := sd.diagramStates()
+
+	// Mermaid ids can't hold arbitrary names, so the states get generated ids
+	ids := make(map[string]string, len(states))
+	for i, state := range states {
+		ids[state.Name.String()] = fmt.Sprintf("s%d", i)
+	}
+
+	for _, state := range states {
+		fmt.Fprintf(&b, "\tstate \"%s\" as %s\n", mermaidEscape(state.Name.String()), ids[state.Name.String()])
+	}
+
+	if main, err := sd.getMainState(); err == nil {
+		if id, ok := ids[main.String()]; ok {
+			fmt.Fprintf(&b, "\t[*] --> %s\n", id)
+		}
+	}
+
+	for _, state := range states {
+		for _, status := range sortedKeys(state.Next) {
+			edge := fmt.Sprintf("\t%s --> %s", ids[state.Name.String()], ids[state.Next[status].Name.String()])
+			if status != "" {
+				edge += " : " + mermaidEscape(status)
+			}
+
+			b.WriteString(edge + "\n")
+		}
+
+		if state.Terminal {
+			fmt.Fprintf(&b, "\t%s --> [*]\n", ids[state.Name.String()])
+		}
+	}
+
+	for _, state := range states {
+		if state.StateType == StateTypeWaitEvent {
+			fmt.Fprintf(&b, "\tclass %s waitEvent\n", ids[state.Name.String()])
+		}
+	}
+
+	return b.String()
+}
+
+// diagramStates returns the registered states and the next states which are not registered, sorted by name
+func (sd *StateDetector[T]) diagramStates() []*State[T] {
+	byName := make(map[string]*State[T], len(sd.states))
+	for name, state := range sd.states {
+		byName[name] = state
+	}
+
+	for _, state := range sd.states {
+		for _, next := range state.Next {
+			if _, ok := byName[next.Name.String()]; !ok {
+				byName[next.Name.String()] = next
+			}
+		}
+	}
+
+	states := make([]*State[T], 0, len(byName))
+	for _, state := range byName {
+		states = append(states, state)
+	}
+
+	slices.SortFunc(states, func(a, b *State[T]) int {
+		return strings.Compare(a.Name.String(), b.Name.String())
+	})
+
+	return states
+}
+
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// mermaidEscape replaces the characters breaking the Mermaid text with the entity codes,
+// the line breaks are replaced with spaces
+var mermaidEscape = strings.NewReplacer(
+	`#`, `#35;`, `"`, `#quot;`, `;`, `#59;`, "\n", " ", "\r", " ",
+).Replace
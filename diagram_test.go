@@ -0,0 +1,48 @@
+package event_fsm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiagrams(t *testing.T) {
+	sd := NewStateDetector[*eventData]()
+
+	firstCheck := sd.NewState(StateFirstCheck, &stateFirstCheck{}, StateTypeTransition)
+	manualAdd := sd.NewState(StateManualAdd, &stateManualAdd{}, StateTypeWaitEvent)
+	printResult := sd.NewState(StatePrintResult, &statePrintResult{t}, StateTypeTransition)
+	sd.SetMainState(StateFirstCheck)
+
+	firstCheck.SetNext(manualAdd, ResultStatusOk)
+	manualAdd.SetNext(printResult, ResultStatusOk)
+	printResult.SetTerminal()
+
+	dot := sd.DOT()
+	for _, line := range []string{
+		`__start -> "StateFirstCheck";`,
+		`"StateManualAdd" [style="rounded,filled", fillcolor="#fff3cd"];`,
+		`"StatePrintResult" [peripheries=2];`,
+		`"StateFirstCheck" -> "StateManualAdd" [label="ok"];`,
+	} {
+		if !strings.Contains(dot, line) {
+			t.Fatalf("DOT has no %s:\n%s", line, dot)
+		}
+	}
+
+	printResult.SetNext(&State[*eventData]{Name: `say "hé"; #1`}, `"again"`)
+
+	mermaid := sd.Mermaid()
+	for _, line := range []string{
+		`state "say #quot;hé#quot;#59; #35;1" as s3`,
+		`s2 --> s3 : #quot;again#quot;`,
+		`state "StateFirstCheck" as s0`,
+		`[*] --> s0`,
+		`s0 --> s1 : ok`,
+		`s2 --> [*]`,
+		`class s1 waitEvent`,
+	} {
+		if !strings.Contains(mermaid, line) {
+			t.Fatalf("Mermaid has no %s:\n%s", line, mermaid)
+		}
+	}
+}
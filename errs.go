@@ -1,12 +1,65 @@
 package event_fsm
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
-	ErrEmptyStateName    = errors.New("empty state")
-	ErrStateNotFound     = errors.New("state not found")
-	ErrStateNameNotFound = errors.New("state name not found")
-	ErrMainStateNotFound = errors.New("main state not found")
-	ErrNoNextState       = errors.New("no next state found")
-	ErrLastLogNotFound   = errors.New("last log not found")
+	ErrEmptyStateName     = errors.New("empty state")
+	ErrStateNotFound      = errors.New("state not found")
+	ErrStateNameNotFound  = errors.New("state name not found")
+	ErrMainStateNotFound  = errors.New("main state not found")
+	ErrNoNextState        = errors.New("no next state found")
+	ErrLastLogNotFound    = errors.New("last log not found")
+	ErrInvalidCursor      = errors.New("invalid cursor")
+	ErrTargetBusy         = errors.New("target is busy")
+	ErrEventEnqueued      = errors.New("event is enqueued")
+	ErrLockLost           = errors.New("target lock is lost")
+	ErrTenantNotSet       = errors.New("tenant is not set in context")
+	ErrNotSupported       = errors.New("not supported by the store")
+	ErrTargetNotFound     = errors.New("target not found")
+	ErrStateFailed        = errors.New("state execution failed")
+	ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+	// ErrConcurrentModification is returned when the state of the target was changed by another worker,
+	// the event can be processed again with the reloaded target
+	ErrConcurrentModification = errors.New("target is modified concurrently")
 )
+
+// ExecutionError is returned by ProcessEvent when the executor of the state fails
+type ExecutionError struct {
+	State    StateName
+	TargetID string
+	EventID  string
+
+	// Attempt is the number of the failed execution, starting from 1
+	Attempt int
+
+	// Err is the error returned by the executor or *PanicError if the executor panicked
+	Err error
+}
+
+func (e *ExecutionError) Error() string {
+	return fmt.Sprintf("state %s of target %s, event %s: %v", e.State, e.TargetID, e.EventID, e.Err)
+}
+
+func (e *ExecutionError) Unwrap() error {
+	return e.Err
+}
+
+// PanicError is the panic recovered during processing of the event
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Unwrap returns the panic value if it is an error
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
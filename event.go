@@ -2,45 +2,86 @@ package event_fsm
 
 import (
 	"encoding/json"
+	"fmt"
 	"time"
 )
 
 type Event struct {
 	ID               string
 	TargetID         string
+	CurrentState     StateName // state the target is in after the event
 	LastResultStatus ResultStatus
 	MetaInfo         json.RawMessage
+	Payload          EventPayload // kept in its own column, meta_info is the TargetData.MetaInfo as is
+	IdempotencyKey   string       // key deduplicating the event, see Target.SetIdempotencyKey
 	CreatedAt        time.Time
 	UpdatedAt        time.Time
 }
 
+// EventPayload is the incoming event the target is processed with,
+// e.g. the webhook body or the user reply which resumes the target waiting in StateTypeWaitEvent state
+type EventPayload struct {
+	Name string          `json:"name"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+func (p EventPayload) IsEmpty() bool {
+	return p.Name == "" && len(p.Data) == 0
+}
+
 type eventDto struct {
 	ID               string          `db:"id" json:"id"`
-	TargetID         string          `db:"entity_id" json:"entity_id"`
-	LastResultStatus ResultStatus    `db:"last_result_status" json:"last_result_status"`
+	TargetID         string          `db:"target_id" json:"target_id"`
+	CurrentState     string          `db:"current_state" json:"current_state"`
+	LastResultStatus string          `db:"last_result_status" json:"last_result_status"`
 	MetaInfo         json.RawMessage `db:"meta_info" json:"meta_info"`
+	Payload          json.RawMessage `db:"payload" json:"payload,omitempty"`
+	IdempotencyKey   string          `db:"idempotency_key" json:"idempotency_key,omitempty"`
 	CreatedAt        time.Time       `db:"created_at" json:"created_at"`
 	UpdatedAt        time.Time       `db:"updated_at" json:"updated_at"`
 }
 
-func (e *eventDto) toEvent() Event {
-	return Event{
+func (e *eventDto) toEvent() (Event, error) {
+	event := Event{
 		ID:               e.ID,
 		TargetID:         e.TargetID,
-		LastResultStatus: e.LastResultStatus,
+		CurrentState:     StateName(e.CurrentState),
+		LastResultStatus: ResultStatus(e.LastResultStatus),
 		MetaInfo:         e.MetaInfo,
+		IdempotencyKey:   e.IdempotencyKey,
 		CreatedAt:        e.CreatedAt,
 		UpdatedAt:        e.UpdatedAt,
 	}
+
+	if len(e.Payload) > 0 {
+		if err := json.Unmarshal(e.Payload, &event.Payload); err != nil {
+			return Event{}, fmt.Errorf("json.Unmarshal of payload of event %s: %w", e.ID, err)
+		}
+	}
+
+	return event, nil
 }
 
-func eventToDTO(e Event) eventDto {
-	return eventDto{
+func eventToDTO(e Event) (eventDto, error) {
+	dto := eventDto{
 		ID:               e.ID,
 		TargetID:         e.TargetID,
-		LastResultStatus: e.LastResultStatus,
+		CurrentState:     e.CurrentState.String(),
+		LastResultStatus: e.LastResultStatus.String(),
 		MetaInfo:         e.MetaInfo,
+		IdempotencyKey:   e.IdempotencyKey,
 		CreatedAt:        e.CreatedAt,
 		UpdatedAt:        e.UpdatedAt,
 	}
+
+	if !e.Payload.IsEmpty() {
+		payload, err := json.Marshal(e.Payload)
+		if err != nil {
+			return eventDto{}, fmt.Errorf("json.Marshal of payload: %w", err)
+		}
+
+		dto.Payload = payload
+	}
+
+	return dto, nil
 }
@@ -0,0 +1,76 @@
+package event_fsm
+
+import (
+	"context"
+)
+
+// Execution describes the current execution of the state
+type Execution[T comparable] struct {
+	// Data is the data of the target
+	Data T
+
+	// TargetID is the ID of the target
+	TargetID string
+
+	// EventID is the ID of the event the target is processed with
+	EventID string
+
+	// State is the name of the executed state
+	State StateName
+
+	// Payload is the incoming event passed to FSM.ProcessEventWithPayload,
+	// it is empty if the target is processed with FSM.ProcessEvent
+	Payload EventPayload
+}
+
+// ContextExecutor is the executor which receives the whole execution of the state,
+// including the event payload. Register it with StateDetector.NewContextState.
+type ContextExecutor[T comparable] interface {
+	ExecuteContext(ctx context.Context, exec Execution[T]) (ResultStatus, error)
+}
+
+// contextExecutor adapts ContextExecutor to Executor, the execution is taken from the context
+type contextExecutor[T comparable] struct {
+	executor ContextExecutor[T]
+}
+
+func (e contextExecutor[T]) Execute(ctx context.Context, data T) (ResultStatus, error) {
+	info, _ := ctx.Value(executionKey{}).(executionInfo)
+
+	return e.executor.ExecuteContext(ctx, Execution[T]{
+		Data:     data,
+		TargetID: info.targetID,
+		EventID:  info.eventID,
+		State:    info.state,
+		Payload:  info.payload,
+	})
+}
+
+type executionKey struct{}
+
+type executionInfo struct {
+	targetID string
+	eventID  string
+	state    StateName
+	payload  EventPayload
+}
+
+// EventPayloadFromContext returns the payload of the event the target is processed with.
+// The context passed to Executor.Execute carries it, so the plain executors can read it too.
+func EventPayloadFromContext(ctx context.Context) (EventPayload, bool) {
+	info, ok := ctx.Value(executionKey{}).(executionInfo)
+	if !ok || info.payload.IsEmpty() {
+		return EventPayload{}, false
+	}
+
+	return info.payload, true
+}
+
+func withExecution[T comparable](ctx context.Context, t Target[T]) context.Context {
+	return context.WithValue(ctx, executionKey{}, executionInfo{
+		targetID: t.ID(),
+		eventID:  t.eventID,
+		state:    t.state.Name,
+		payload:  t.payload,
+	})
+}
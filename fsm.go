@@ -4,19 +4,43 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"runtime/debug"
+	"time"
 
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
-const (
-	searchPath = "fsm"
-)
-
 type FSM[T comparable] struct {
 	l *zap.Logger
 
-	store *storage
+	store Store
+
+	locker   Locker
+	lockMode LockMode
+	pending  *pendingEvents[T]
+
+	idempotencyRetention time.Duration
+
+	// tx runs the steps in transactions if Config.Transactional is set
+	tx TxStore
+
+	// outbox keeps the outbox entries if Config.Outbox is set
+	outbox OutboxStore
+
+	// deadLetters records the failed events if Config.DeadLetters is set
+	deadLetters DeadLetterStore
+
+	// queue keeps the events of Enqueue, see Config.Queue
+	queue QueueStore
+
+	// targets keeps the versions of the targets if Config.VersionedTargets or Config.ManagedState is set
+	targets      TargetStore
+	managedState bool
+
+	// tracer creates the spans of processing, see Config.TracerProvider
+	tracer trace.Tracer
 
 	stateDetector *StateDetector[T]
 }
@@ -26,36 +50,234 @@ func NewFSM[T comparable](cfg *Config[T]) (*FSM[T], error) {
 		return nil, fmt.Errorf("cfg.check() failed: %w", err)
 	}
 
-	dbConn, err := initDB(cfg)
-	if err != nil {
-		return nil, fmt.Errorf("initDB failed: %w", err)
+	store, locker := cfg.Store, cfg.Locker
+	if store == nil {
+		s, err := initStorage(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("initStorage failed: %w", err)
+		}
+
+		if locker == nil {
+			locker = NewRedisLocker(s.cache.rdb, lockKeyPrefix+cfg.AppLabel+":", defaultLockTTL)
+		}
+
+		store = s
 	}
 
-	db := newDBStore(dbConn)
+	if locker == nil {
+		locker = NewMemoryLocker()
+	}
 
-	rdb, err := initRedis(cfg)
-	if err != nil {
-		return nil, fmt.Errorf("initRedis failed: %w", err)
+	if _, ok := store.(SchedulerStore); !ok && cfg.StateDetector.hasTimeouts() {
+		return nil, fmt.Errorf("state timeouts: %w", ErrNotSupported)
+	}
+
+	var targets TargetStore
+	if cfg.VersionedTargets || cfg.ManagedState {
+		var ok bool
+		if targets, ok = store.(TargetStore); !ok {
+			return nil, fmt.Errorf("versioned targets: %w", ErrNotSupported)
+		}
+	}
+
+	var tx TxStore
+	if cfg.Transactional {
+		var ok bool
+		if tx, ok = store.(TxStore); !ok {
+			return nil, fmt.Errorf("transactions: %w", ErrNotSupported)
+		}
+	}
+
+	var outbox OutboxStore
+	if cfg.Outbox {
+		var ok bool
+		if outbox, ok = store.(OutboxStore); !ok {
+			return nil, fmt.Errorf("outbox: %w", ErrNotSupported)
+		}
+	}
+
+	var deadLetters DeadLetterStore
+	if cfg.DeadLetters {
+		var ok bool
+		if deadLetters, ok = store.(DeadLetterStore); !ok {
+			return nil, fmt.Errorf("dead letters: %w", ErrNotSupported)
+		}
+	}
+
+	queue := cfg.Queue
+	if queue == nil {
+		queue, _ = store.(QueueStore)
+	}
+
+	idempotencyRetention := cfg.IdempotencyRetention
+	if idempotencyRetention <= 0 {
+		idempotencyRetention = defaultIdempotencyRetention
 	}
 
 	return &FSM[T]{
 		stateDetector: cfg.StateDetector,
 		l:             cfg.Logger,
 
-		store: newStorage(cfg.Logger, cfg.AppLabel, db, rdb),
+		store: store,
+
+		locker:   locker,
+		lockMode: cfg.LockMode,
+		pending:  newPendingEvents[T](),
+
+		idempotencyRetention: idempotencyRetention,
+		targets:              targets,
+		managedState:         cfg.ManagedState,
+		tx:                   tx,
+		outbox:               outbox,
+		deadLetters:          deadLetters,
+		queue:                queue,
+		tracer:               newTracer(cfg.TracerProvider),
 	}, nil
 }
 
+// ProcessEvent runs the states of the target starting from its current state
+// until a state waiting for the next event is reached.
+// The events of one target are processed one at a time, see Config.LockMode.
 func (f *FSM[T]) ProcessEvent(ctx context.Context, t Target[T]) (Target[T], error) {
+	return f.ProcessEventWithPayload(ctx, t, EventPayload{})
+}
+
+// ProcessEventWithPayload is ProcessEvent with the incoming event payload.
+// The payload is saved with the event and passed to the executors of the states run for it,
+// see ContextExecutor and EventPayloadFromContext.
+func (f *FSM[T]) ProcessEventWithPayload(ctx context.Context, t Target[T], payload EventPayload) (Target[T], error) {
 	// check if the target is nil
 	if t.data.IsNull() {
 		return t, fmt.Errorf("target is nil")
 	}
 
+	t.payload = payload
+
+	switch f.lockMode {
+	case LockModeFailFast:
+		return f.lockAndProcess(ctx, t, false)
+	case LockModeEnqueue:
+		key := lockKey(ctx, t.ID())
+
+		// don't let the event overtake the already enqueued ones
+		if f.pending.appendIfPending(context.WithoutCancel(ctx), key, t) {
+			return t, ErrEventEnqueued
+		}
+
+		nt, err := f.lockAndProcess(ctx, t, false)
+		if !errors.Is(err, ErrTargetBusy) {
+			return nt, err
+		}
+
+		if f.pending.push(context.WithoutCancel(ctx), key, t) {
+			go f.processPending(key)
+		}
+
+		return t, ErrEventEnqueued
+	default:
+		return f.lockAndProcess(ctx, t, true)
+	}
+}
+
+// ProcessEventWithStatus is ProcessEventWithPayload which applies the status to the current state of the target
+// instead of executing it, e.g. the expired timer of the state waiting for the next event.
+// The empty status runs the current state the same way ProcessEventWithPayload does.
+func (f *FSM[T]) ProcessEventWithStatus(
+	ctx context.Context, t Target[T], status ResultStatus, payload EventPayload,
+) (Target[T], error) {
+	if _, err := f.stateDetector.registry.ParseResultStatus(status.String()); err != nil {
+		return t, err
+	}
+
+	t.resumeStatus = status
+
+	return f.ProcessEventWithPayload(ctx, t, payload)
+}
+
+// processPending processes the enqueued events of the target with the lock key one by one until the queue is empty
+func (f *FSM[T]) processPending(key string) {
+	for {
+		e := f.pending.first(key)
+
+		if _, err := f.lockAndProcess(e.ctx, e.t, true); err != nil {
+			f.l.Error("error processing enqueued event", zap.Error(err), zap.String("target_id", e.t.ID()))
+		}
+
+		if !f.pending.removeFirst(key) {
+			return
+		}
+	}
+}
+
+// lockKey is the key of the target lock, qualified by the tenant of the context
+func lockKey(ctx context.Context, targetID string) string {
+	if tenantID, ok := TenantFromContext(ctx); ok {
+		return tenantID + ":" + targetID
+	}
+
+	return targetID
+}
+
+// lockAndProcess processes the event holding the lock of the target.
+// The context is canceled with ErrLockLost if the lock is lost during processing.
+func (f *FSM[T]) lockAndProcess(ctx context.Context, t Target[T], wait bool) (nt Target[T], err error) {
+	ctx, span := f.tracer.Start(ctx, "fsm.ProcessEvent", trace.WithAttributes(attrTargetID.String(t.ID())))
+	defer func() {
+		span.SetAttributes(attrResultStatus.String(nt.stateResult.String()))
+		endSpan(span, err)
+	}()
+
+	var lock Lock
+
+	key := lockKey(ctx, t.ID())
+	if wait {
+		lock, err = f.locker.Lock(ctx, key)
+	} else {
+		lock, err = f.locker.TryLock(ctx, key)
+	}
+	if err != nil {
+		return t, fmt.Errorf("f.locker.Lock: %w", err)
+	}
+
+	defer func() {
+		if err := lock.Unlock(context.WithoutCancel(ctx)); err != nil {
+			f.l.Error("error unlocking target", zap.Error(err), zap.String("target_id", t.ID()))
+		}
+	}()
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	if lost := lock.Lost(); lost != nil {
+		go func() {
+			select {
+			case <-lost:
+				cancel(ErrLockLost)
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	return f.processTarget(withLockToken(ctx, lock.Token()), t)
+}
+
+func (f *FSM[T]) processTarget(ctx context.Context, t Target[T]) (Target[T], error) {
+	if err := f.loadVersion(ctx, &t); err != nil {
+		return t, err
+	}
+
+	if t.startState != "" {
+		// the event is replayed from the chosen state
+		t.data.SetState(t.startState)
+	}
+
 	// determine current state
 	currentStateName := t.getStateName()
+	defer func() {
+		trace.SpanFromContext(ctx).SetAttributes(attrEventID.String(t.eventID))
+	}()
 
-	if ok, err := checkStateName(currentStateName); !ok {
+	if ok, err := f.stateDetector.registry.checkStateName(currentStateName); !ok {
 		if errors.Is(err, ErrStateNotFound) {
 			return t, fmt.Errorf("invalid state name: %s, %w", currentStateName, ErrStateNotFound)
 		}
@@ -64,20 +286,55 @@ func (f *FSM[T]) ProcessEvent(ctx context.Context, t Target[T]) (Target[T], erro
 		if err != nil {
 			return t, fmt.Errorf("f.stateDetector.getMainState: %w", err)
 		}
+
+		// the new target enters the main state
+		t.entered = true
 	}
 
 	var (
 		err error
 	)
 
+	if t.boundState != "" && t.boundState != currentStateName {
+		// the target has left the state the scheduled event is bound to
+		return t, nil
+	}
+
 	if t.state, err = f.stateDetector.stateByName(currentStateName); err != nil {
 		return t, fmt.Errorf("%v: %w, state: %s", ErrStateNotFound, err, currentStateName)
 	}
 
+	trace.SpanFromContext(ctx).SetAttributes(attrState.String(currentStateName.String()))
+
+	if t.resumeEventID != "" {
+		// the abandoned event is resumed
+		t.eventID = t.resumeEventID
+
+		return f.processEvent(ctx, t)
+	}
+
+	// the duplicate event returns the outcome of the original one
+	event, ok, err := f.processedEvent(ctx, t)
+	if err != nil {
+		return t, err
+	}
+
+	if ok {
+		if event.CurrentState != "" {
+			if t.state, err = f.stateDetector.stateByName(event.CurrentState); err != nil {
+				return t, fmt.Errorf("%v: %w, state: %s", ErrStateNotFound, err, event.CurrentState)
+			}
+		}
+
+		t.eventID, t.stateResult, t.duplicate = event.ID, event.LastResultStatus, true
+
+		return t, nil
+	}
+
 	t.eventID = uuid.NewString()
-	t.eventID, err = f.store.saveEvent(ctx, t.event())
+	t.eventID, err = f.store.SaveEvent(ctx, t.event())
 	if err != nil {
-		return t, fmt.Errorf("f.store.saveEvent: %w", err)
+		return t, fmt.Errorf("f.store.SaveEvent: %w", err)
 	}
 
 	return f.processEvent(ctx, t)
@@ -85,61 +342,228 @@ func (f *FSM[T]) ProcessEvent(ctx context.Context, t Target[T]) (Target[T], erro
 
 func (f *FSM[T]) processEvent(ctx context.Context, t Target[T]) (nt Target[T], err error) {
 	defer func() {
-		if err := recover(); err != nil {
-			f.l.Error("panic in FSM", zap.Any("err", err))
+		if r := recover(); r != nil {
+			f.l.Error("panic in FSM", zap.Any("err", r))
 
-			nt = t
+			nt, err = t, &PanicError{Value: r, Stack: debug.Stack()}
 		}
 	}()
 
-	var (
-		ok bool
-	)
-
 	for {
-		id, err := f.store.saveLog(ctx, t.log())
+		done, err := f.runStep(ctx, &t)
 		if err != nil {
-			return t, fmt.Errorf("f.store.createLog: %w", err)
+			f.saveDeadLetter(ctx, t, err)
+			return t, err
 		}
 
-		t.stateResult, err = t.state.Executor.Execute(ctx, t.data.Data())
-		if err != nil {
-			f.l.Error("error executing state", zap.Error(err), zap.String("state", t.state.Name.String()))
+		if done {
+			return t, nil
 		}
+	}
+}
 
-		log := t.log()
-		log.ID = id
-		if err = f.store.updateLog(ctx, log); err != nil {
-			return t, fmt.Errorf("f.store.updateLog: %w", err)
-		}
+// step runs the current state from the attempt and moves the target to the next one,
+// it returns true when the processing of the event is finished
+func (f *FSM[T]) step(ctx context.Context, t *Target[T], attempt int) (bool, error) {
+	if err := f.runState(ctx, t, attempt); err != nil {
+		return true, err
+	}
+
+	if t.stateResult == ResultStatusFail {
+		return true, fmt.Errorf("%w: %s", ErrStateFailed, t.state.Name)
+	}
+
+	next, ok := f.stateDetector.getNextState(t.state, t.stateResult)
+	if !ok && !t.state.Terminal {
+		return true, fmt.Errorf("no next state for %s: %w", t.state.Name, ErrNoNextState)
+	}
+
+	// the state is finished, the target doesn't wait for its timeout anymore
+	if err := f.cancelTimeouts(ctx, *t); err != nil {
+		return true, fmt.Errorf("f.cancelTimeouts: %w", err)
+	}
+
+	if !ok {
+		// the flow is finished
+		return true, nil
+	}
+
+	t.state = next
+
+	// the waiting state is entered with the log of waiting for the next event
+	t.entered = next.StateType != StateTypeWaitEvent
+
+	if err := f.swapState(ctx, t); err != nil {
+		return true, err
+	}
+
+	t.setStateName(t.state)
+
+	if err := t.save(ctx); err != nil {
+		return true, fmt.Errorf("t.save: %w", err)
+	}
+
+	if t.state.StateType != StateTypeWaitEvent {
+		return false, nil
+	}
+
+	// the event is finished in the waiting state
+	if err := f.store.UpdateEvent(ctx, t.event()); err != nil {
+		return true, fmt.Errorf("f.store.UpdateEvent: %w", err)
+	}
+
+	// wait for the next event
+	log := withTrace(ctx, t.log())
+	log.CurrentResultStatus = resultStatusWaitNextEvent
+	if _, err := f.saveEnteredLog(ctx, log, f.store.CreateFullLog); err != nil {
+		return true, fmt.Errorf("f.store.createLog: %w", err)
+	}
+
+	if err := f.scheduleTimeout(ctx, *t); err != nil {
+		return true, fmt.Errorf("f.scheduleTimeout: %w", err)
+	}
+
+	return true, nil
+}
+
+// runState executes the current state from the attempt, the failed executions are retried by the retry policy
+// of the state. Every execution is recorded in the logs with its attempt number.
+// The failed execution of the transactional step is returned as *ExecutionError, it is retried by runStep.
+func (f *FSM[T]) runState(ctx context.Context, t *Target[T], attempt int) error {
+	if t.resumeStatus != ResultStatusEmpty {
+		// the state is resumed with the given status, it is recorded without execution
+		t.stateResult, t.resumeStatus = t.resumeStatus, ResultStatusEmpty
+
+		return f.recordStatus(ctx, t, attempt)
+	}
 
-		if err = f.store.updateEvent(ctx, t.event()); err != nil {
-			return t, fmt.Errorf("f.store.updateEvent: %w", err)
+	for ; ; attempt++ {
+		execErr, err := f.runAttempt(ctx, t, attempt)
+		if err != nil {
+			return err
 		}
 
-		if t.stateResult == ResultStatusFail {
-			return t, fmt.Errorf("state execution failed: %s", t.state.Name)
+		if execErr == nil {
+			return nil
 		}
 
-		t.state, ok = f.stateDetector.getNextState(t.state, t.stateResult)
-		if !ok {
-			return t, fmt.Errorf("no next state for %s: %w", log.CurrentStateName, ErrNoNextState)
+		if f.tx != nil {
+			// the step is rolled back, the retry runs in the new transaction
+			return &ExecutionError{
+				State:    t.state.Name,
+				TargetID: t.ID(),
+				EventID:  t.eventID,
+				Attempt:  attempt,
+				Err:      execErr,
+			}
 		}
 
-		t.setStateName(t.state)
+		retry := t.state.Retry
+		if retry.shouldRetry(attempt, execErr) {
+			if err = sleep(ctx, retry.backoff(attempt)); err != nil {
+				return fmt.Errorf("retry of state %s: %w", t.state.Name, err)
+			}
 
-		if err = t.save(ctx); err != nil {
-			return t, fmt.Errorf("t.save: %w", err)
+			continue
 		}
 
-		if t.state.StateType == StateTypeWaitEvent {
-			// wait for the next event
-			t.stateResult = resultStatusWaitNextEvent
-			if _, err = f.store.createFullLog(ctx, t.log()); err != nil {
-				return t, fmt.Errorf("f.store.createLog: %w", err)
-			}
+		if retry != nil && retry.ExhaustedStatus != ResultStatusEmpty {
+			// the retries are exhausted, the state transitions with the configured status
+			t.stateResult = retry.ExhaustedStatus
 
-			return t, nil
+			return f.recordStatus(ctx, t, attempt)
 		}
+
+		return &ExecutionError{
+			State:    t.state.Name,
+			TargetID: t.ID(),
+			EventID:  t.eventID,
+			Attempt:  attempt,
+			Err:      execErr,
+		}
+	}
+}
+
+// recordStatus records the result status of the current state applied without its execution,
+// the attempt is the last execution of the state, if any
+func (f *FSM[T]) recordStatus(ctx context.Context, t *Target[T], attempt int) error {
+	log := withTrace(ctx, t.log())
+	log.Attempt = attempt
+
+	if _, err := f.saveLog(ctx, t, log, f.store.CreateFullLog); err != nil {
+		return fmt.Errorf("f.store.CreateFullLog: %w", err)
+	}
+
+	if err := f.store.UpdateEvent(ctx, t.event()); err != nil {
+		return fmt.Errorf("f.store.UpdateEvent: %w", err)
+	}
+
+	return nil
+}
+
+// runAttempt executes the current state once within its span and records the execution in the log,
+// it returns the error of the executor and the error of the persistence
+func (f *FSM[T]) runAttempt(ctx context.Context, t *Target[T], attempt int) (execErr, err error) {
+	ctx, span := f.tracer.Start(ctx, "fsm.state "+t.state.Name.String(), trace.WithAttributes(
+		attrState.String(t.state.Name.String()),
+		attrAttempt.Int(attempt),
+	))
+	defer func() {
+		span.SetAttributes(attrResultStatus.String(t.stateResult.String()))
+		endSpan(span, errors.Join(execErr, err))
+	}()
+
+	log := withTrace(ctx, t.log())
+	log.Attempt = attempt
+
+	// the retries and the next events executing the same state are not notified
+	id, err := f.saveLog(ctx, t, log, f.store.SaveLog)
+	if err != nil {
+		return nil, fmt.Errorf("f.store.createLog: %w", err)
+	}
+
+	if t.stateResult, execErr = f.execute(ctx, *t); execErr != nil {
+		f.l.Error("error executing state", zap.Error(execErr),
+			zap.String("state", t.state.Name.String()), zap.Int("attempt", attempt))
+
+		t.stateResult = ResultStatusFail
+	}
+
+	log = withTrace(ctx, t.log())
+	log.ID = id
+	log.Attempt = attempt
+	if execErr != nil {
+		log.Error = errorText(execErr)
+	}
+
+	if err = f.store.UpdateLog(ctx, log); err != nil {
+		return execErr, fmt.Errorf("f.store.UpdateLog: %w", err)
+	}
+
+	if err = f.store.UpdateEvent(ctx, t.event()); err != nil {
+		return execErr, fmt.Errorf("f.store.UpdateEvent: %w", err)
 	}
+
+	return execErr, nil
+}
+
+// errorText is the error of the executor recorded in the log, the panic is recorded with its stack
+func errorText(err error) string {
+	var panicErr *PanicError
+	if errors.As(err, &panicErr) {
+		return err.Error() + "\n" + string(panicErr.Stack)
+	}
+
+	return err.Error()
+}
+
+// execute runs the executor of the current state, the panic of the executor is returned as *PanicError
+func (f *FSM[T]) execute(ctx context.Context, t Target[T]) (status ResultStatus, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			status, err = ResultStatusFail, &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+
+	return t.state.Executor.Execute(withExecution(ctx, t), t.data.Data())
 }
@@ -8,7 +8,6 @@ import (
 	"strconv"
 	"sync"
 	"testing"
-	"time"
 
 	"go.uber.org/zap"
 )
@@ -147,19 +146,15 @@ func TestProcess(t *testing.T) {
 	lastCheck.SetNext(firstCheck, WrongNumber)
 	lastCheck.SetNext(printResult, ResultStatusOk)
 
+	printResult.SetTerminal()
+
 	zap.NewNop().Error("State detector initialized")
 	cfg := &Config[*eventData]{
 		Logger:        zap.NewNop(),
 		StateDetector: sd,
-		DBConf:        "host=localhost port=5432 user=user password=qwerty dbname=fsm_test_db sslmode=disable",
-		RedisConf: &Redis{
-			URL:      "localhost:6379",
-			Password: "qwerty",
-		},
-		AppLabel:              "fsm_test",
-		MaxOpenConnections:    5,
-		MaxIdleConnections:    5,
-		ConnectionMaxLifetime: 5 * time.Second,
+		Store:         NewMemoryStore(),
+		// the executors leave the cycles of StateFirstCheck once the number is right
+		ValidationWarnings: []ValidationCheck{CheckTransitionCycle},
 	}
 
 	fsm, err := NewFSM[*eventData](cfg)
@@ -178,18 +173,13 @@ func TestProcess(t *testing.T) {
 
 			target, err := fsm.ProcessEvent(context.Background(), NewTarget(&ed))
 			if err != nil {
-				if errors.Is(err, ErrNoNextState) {
-					t.Log("For test need to clean data base and redis")
-
-					return
-				}
-				t.Fatal("Error processing event:", err.Error())
+				t.Error("Error processing event:", err.Error())
 				return
 
 			}
 
 			if _, ok := target.Data(); !ok {
-				t.Fatal("Data is nil")
+				t.Error("Data is nil")
 				return
 			}
 
@@ -198,7 +188,7 @@ func TestProcess(t *testing.T) {
 			_, err = fsm.ProcessEvent(context.Background(), NewTarget(&ed))
 			if err != nil {
 				if !errors.Is(err, ErrNoNextState) {
-					t.Fatal("Error processing event:", err.Error())
+					t.Error("Error processing event:", err.Error())
 					return
 				}
 			}
@@ -302,3 +292,109 @@ func TestStateName(t *testing.T) {
 		t.Fatalf("expected %s, got %s", sn.String(), nsn.String())
 	}
 }
+
+type stateReply struct {
+	payloads chan EventPayload
+}
+
+func (s *stateReply) ExecuteContext(ctx context.Context, exec Execution[*eventData]) (ResultStatus, error) {
+	s.payloads <- exec.Payload
+
+	return ResultStatusOk, nil
+}
+
+func TestProcessEventWithPayload(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	reply := &stateReply{payloads: make(chan EventPayload, 1)}
+
+	sd := NewStateDetector[*eventData]()
+	manualAdd := sd.NewContextState(StateManualAdd, reply, StateTypeWaitEvent)
+	manualAdd.SetNext(manualAdd, ResultStatusOk)
+	sd.SetMainState(StateManualAdd)
+
+	fsm, err := NewFSM(&Config[*eventData]{Logger: zap.NewNop(), StateDetector: sd, Store: store})
+	if err != nil {
+		t.Fatalf("NewFSM: %v", err)
+	}
+
+	ed := newEventData(1)
+	payload := EventPayload{Name: "user_reply", Data: json.RawMessage(`{"text":"yes"}`)}
+	if _, err = fsm.ProcessEventWithPayload(ctx, NewTarget(&ed), payload); err != nil {
+		t.Fatalf("ProcessEventWithPayload: %v", err)
+	}
+
+	if got := <-reply.payloads; got.Name != payload.Name || string(got.Data) != string(payload.Data) {
+		t.Fatalf("expected payload %+v, got %+v", payload, got)
+	}
+
+	page, err := fsm.Events(ctx, ed.ID(), EventsFilter{})
+	if err != nil {
+		t.Fatalf("Events: %v", err)
+	}
+
+	dto, err := eventToDTO(page.Events[0])
+	if err != nil {
+		t.Fatalf("eventToDTO: %v", err)
+	}
+
+	event, err := dto.toEvent()
+	if err != nil || event.Payload.Name != payload.Name || string(event.MetaInfo) != string(ed.MetaInfo()) {
+		t.Fatalf("payload is not kept: %s, meta info: %s, %v", dto.Payload, dto.MetaInfo, err)
+	}
+
+	// the corrupt payload is not read as empty
+	dto.Payload = json.RawMessage(`{"name":`)
+	if _, err = dto.toEvent(); err == nil {
+		t.Fatal("expected the error of the corrupt payload")
+	}
+
+	if _, err = eventToDTO(Event{Payload: EventPayload{Data: json.RawMessage(`{`)}}); err == nil {
+		t.Fatal("expected the error of the invalid payload")
+	}
+}
+
+type stateFailing struct {
+	panic bool
+}
+
+func (s *stateFailing) Execute(ctx context.Context, data *eventData) (ResultStatus, error) {
+	if s.panic {
+		panic("executor panic")
+	}
+
+	return ResultStatusOk, errors.New("executor error")
+}
+
+func TestProcessEventExecutionError(t *testing.T) {
+	for _, panics := range []bool{false, true} {
+		store := NewMemoryStore()
+
+		sd := NewStateDetector[*eventData]()
+		sd.NewState(StateFirstCheck, &stateFailing{panic: panics}, StateTypeTransition).SetTerminal()
+		sd.SetMainState(StateFirstCheck)
+
+		fsm, err := NewFSM(&Config[*eventData]{Logger: zap.NewNop(), StateDetector: sd, Store: store})
+		if err != nil {
+			t.Fatalf("NewFSM: %v", err)
+		}
+
+		ed := newEventData(1)
+		_, err = fsm.ProcessEvent(context.Background(), NewTarget(&ed))
+
+		var execErr *ExecutionError
+		if !errors.As(err, &execErr) || execErr.State != StateFirstCheck || execErr.EventID == "" {
+			t.Fatalf("expected ExecutionError of %s, got %v", StateFirstCheck, err)
+		}
+
+		var panicErr *PanicError
+		if errors.As(err, &panicErr) != panics {
+			t.Fatalf("expected PanicError: %v, got %v", panics, err)
+		}
+
+		page, _ := fsm.History(context.Background(), ed.ID(), HistoryFilter{})
+		if len(page.Logs) != 1 || page.Logs[0].Error == "" || page.Logs[0].CurrentResultStatus != ResultStatusFail {
+			t.Fatalf("expected the failed log with error, got %+v", page.Logs)
+		}
+	}
+}
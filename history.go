@@ -0,0 +1,146 @@
+package event_fsm
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 1000
+)
+
+// HistoryFilter narrows down the logs returned by FSM.History
+type HistoryFilter struct {
+	// From is the lower bound of the log creation time, inclusive, optional
+	From time.Time
+
+	// To is the upper bound of the log creation time, exclusive, optional
+	To time.Time
+
+	// States returns only the logs of these states, optional
+	States []StateName
+
+	// Cursor is the LogPage.NextCursor of the previous page, optional
+	Cursor string
+
+	// Limit is the maximum number of logs in the page, 100 by default
+	Limit int
+}
+
+// EventsFilter narrows down the events returned by FSM.Events
+type EventsFilter struct {
+	// From is the lower bound of the event creation time, inclusive, optional
+	From time.Time
+
+	// To is the upper bound of the event creation time, exclusive, optional
+	To time.Time
+
+	// Cursor is the EventPage.NextCursor of the previous page, optional
+	Cursor string
+
+	// Limit is the maximum number of events in the page, 100 by default
+	Limit int
+}
+
+// LogPage is a page of the target logs, newest first
+type LogPage struct {
+	Logs []Log
+
+	// NextCursor is empty if there are no more logs
+	NextCursor string
+}
+
+// EventPage is a page of the target events, newest first
+type EventPage struct {
+	Events []Event
+
+	// NextCursor is empty if there are no more events
+	NextCursor string
+}
+
+// History returns the logs of the states the target passed through, newest first
+func (f *FSM[T]) History(ctx context.Context, targetID string, filter HistoryFilter) (LogPage, error) {
+	if _, _, err := decodeCursor(filter.Cursor); err != nil {
+		return LogPage{}, err
+	}
+
+	filter.Limit = historyLimit(filter.Limit)
+
+	page, err := f.store.GetLogs(ctx, targetID, filter)
+	if err != nil {
+		return LogPage{}, fmt.Errorf("f.store.GetLogs: %w", err)
+	}
+
+	return page, nil
+}
+
+// Events returns the events of the target, newest first
+func (f *FSM[T]) Events(ctx context.Context, targetID string, filter EventsFilter) (EventPage, error) {
+	if _, _, err := decodeCursor(filter.Cursor); err != nil {
+		return EventPage{}, err
+	}
+
+	filter.Limit = historyLimit(filter.Limit)
+
+	page, err := f.store.GetEvents(ctx, targetID, filter)
+	if err != nil {
+		return EventPage{}, fmt.Errorf("f.store.GetEvents: %w", err)
+	}
+
+	return page, nil
+}
+
+// Event returns the event by its ID
+func (f *FSM[T]) Event(ctx context.Context, eventID string) (Event, error) {
+	event, err := f.store.GetEvent(ctx, eventID)
+	if err != nil {
+		return Event{}, fmt.Errorf("f.store.GetEvent: %w", err)
+	}
+
+	return event, nil
+}
+
+func historyLimit(limit int) int {
+	if limit <= 0 {
+		return defaultHistoryLimit
+	}
+
+	return min(limit, maxHistoryLimit)
+}
+
+// encodeCursor makes an opaque cursor pointing to the record with the given creation time and ID
+func encodeCursor(createdAt time.Time, id string) string {
+	return base64.RawURLEncoding.EncodeToString(
+		[]byte(strconv.FormatInt(createdAt.UnixNano(), 10) + ":" + id),
+	)
+}
+
+// decodeCursor returns the creation time and ID of the record the cursor points to.
+// An empty cursor is decoded to zero values.
+func decodeCursor(cursor string) (time.Time, string, error) {
+	if cursor == "" {
+		return time.Time{}, "", nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+
+	nanos, id, ok := strings.Cut(string(raw), ":")
+	if !ok || id == "" {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return time.Time{}, "", fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+
+	return time.Unix(0, n), id, nil
+}
@@ -0,0 +1,72 @@
+package event_fsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestHistory(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+
+	sd := NewStateDetector[*eventData]()
+	sd.NewState(StateFirstCheck, &stateFirstCheck{}, StateTypeTransition).SetTerminal()
+	sd.SetMainState(StateFirstCheck)
+
+	fsm, err := NewFSM(&Config[*eventData]{Logger: zap.NewNop(), StateDetector: sd, Store: store})
+	if err != nil {
+		t.Fatalf("NewFSM: %v", err)
+	}
+
+	states := []StateName{StateFirstCheck, StateAdd3, StateFirstCheck, StateAdd3, StateFirstCheck}
+	for _, state := range states {
+		if _, err = store.CreateFullLog(ctx, Log{TargetID: "1", CurrentStateName: state}); err != nil {
+			t.Fatalf("CreateFullLog: %v", err)
+		}
+	}
+
+	seen := make(map[string]bool)
+	filter := HistoryFilter{Limit: 2}
+	for pages := 0; ; pages++ {
+		page, err := fsm.History(ctx, "1", filter)
+		if err != nil {
+			t.Fatalf("History: %v", err)
+		}
+
+		for _, l := range page.Logs {
+			if seen[l.ID] {
+				t.Fatalf("log %s returned twice", l.ID)
+			}
+			seen[l.ID] = true
+		}
+
+		if page.NextCursor == "" {
+			if pages != 2 {
+				t.Fatalf("expected 3 pages, got %d", pages+1)
+			}
+			break
+		}
+
+		filter.Cursor = page.NextCursor
+	}
+
+	if len(seen) != len(states) {
+		t.Fatalf("expected %d logs, got %d", len(states), len(seen))
+	}
+
+	page, err := fsm.History(ctx, "1", HistoryFilter{States: []StateName{StateAdd3}})
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+
+	if len(page.Logs) != 2 {
+		t.Fatalf("expected 2 logs of %s, got %d", StateAdd3, len(page.Logs))
+	}
+
+	if _, err = fsm.History(ctx, "1", HistoryFilter{Cursor: "not a cursor"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Fatalf("expected ErrInvalidCursor, got %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package event_fsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultIdempotencyRetention = 7 * 24 * time.Hour
+)
+
+// IdempotencyStore is implemented by the stores which can find the events by the idempotency keys
+type IdempotencyStore interface {
+	// GetEventByIdempotencyKey returns the event of the target with the key, ErrLastLogNotFound if there is none
+	GetEventByIdempotencyKey(ctx context.Context, targetID, key string) (Event, error)
+
+	// ExpireIdempotencyKeys removes the keys from the events older than the duration,
+	// the events with the same keys are processed again
+	ExpireIdempotencyKeys(ctx context.Context, olderThan time.Duration) error
+
+	// ExpireIdempotencyKey removes the key of the target from its event if the event is older than the duration
+	ExpireIdempotencyKey(ctx context.Context, targetID, key string, olderThan time.Duration) error
+}
+
+// SetIdempotencyKey sets the key deduplicating the event, e.g. the delivery ID of the webhook.
+// The event with the key already processed for the target is not processed again:
+// ProcessEvent returns the outcome of the original event, see Target.Duplicate.
+func (e *Target[T]) SetIdempotencyKey(key string) {
+	e.idempotencyKey = key
+}
+
+// Duplicate is true if the event was already processed with the same idempotency key
+func (e *Target[T]) Duplicate() bool {
+	return e.duplicate
+}
+
+// ExpireIdempotencyKeys removes the idempotency keys older than Config.IdempotencyRetention,
+// it should be called periodically
+func (f *FSM[T]) ExpireIdempotencyKeys(ctx context.Context) error {
+	store, ok := f.store.(IdempotencyStore)
+	if !ok {
+		return fmt.Errorf("idempotency keys: %w", ErrNotSupported)
+	}
+
+	if err := store.ExpireIdempotencyKeys(ctx, f.idempotencyRetention); err != nil {
+		return fmt.Errorf("store.ExpireIdempotencyKeys: %w", err)
+	}
+
+	return nil
+}
+
+// supportsIdempotency is true if the Store can deduplicate the events by the idempotency keys
+func (f *FSM[T]) supportsIdempotency() bool {
+	_, ok := f.store.(IdempotencyStore)
+	return ok
+}
+
+// processedEvent returns the event already processed with the idempotency key of the target
+func (f *FSM[T]) processedEvent(ctx context.Context, t Target[T]) (Event, bool, error) {
+	if t.idempotencyKey == "" {
+		return Event{}, false, nil
+	}
+
+	store, ok := f.store.(IdempotencyStore)
+	if !ok {
+		return Event{}, false, fmt.Errorf("idempotency keys: %w", ErrNotSupported)
+	}
+
+	event, err := store.GetEventByIdempotencyKey(ctx, t.ID(), t.idempotencyKey)
+	if errors.Is(err, ErrLastLogNotFound) {
+		return Event{}, false, nil
+	}
+	if err != nil {
+		return Event{}, false, fmt.Errorf("store.GetEventByIdempotencyKey: %w", err)
+	}
+
+	if time.Since(event.CreatedAt) > f.idempotencyRetention {
+		// the key is expired, but is not removed yet by FSM.ExpireIdempotencyKeys,
+		// only this key is released for the new event
+		if err = store.ExpireIdempotencyKey(ctx, t.ID(), t.idempotencyKey, f.idempotencyRetention); err != nil {
+			return Event{}, false, fmt.Errorf("store.ExpireIdempotencyKey: %w", err)
+		}
+
+		return Event{}, false, nil
+	}
+
+	return event, true, nil
+}
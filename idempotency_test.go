@@ -0,0 +1,76 @@
+package event_fsm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	ctx := context.Background()
+
+	firstExecutor, waitExecutor := &stateFlaky{}, &stateFlaky{}
+
+	sd := NewStateDetector[*eventData]()
+	first := sd.NewState(StateFirstCheck, firstExecutor, StateTypeTransition)
+	wait := sd.NewState(StateManualAdd, waitExecutor, StateTypeWaitEvent)
+	first.SetNext(wait, ResultStatusOk)
+	wait.SetNext(wait, ResultStatusOk)
+	sd.SetMainState(StateFirstCheck)
+
+	store := NewMemoryStore()
+	fsm, err := NewFSM(&Config[*eventData]{
+		Logger:               zap.NewNop(),
+		StateDetector:        sd,
+		Store:                store,
+		IdempotencyRetention: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewFSM: %v", err)
+	}
+
+	ed := newEventData(1)
+	process := func(key string) Target[*eventData] {
+		target := NewTarget(&ed)
+		target.SetIdempotencyKey(key)
+
+		target, err := fsm.ProcessEvent(ctx, target)
+		if err != nil {
+			t.Fatalf("ProcessEvent: %v", err)
+		}
+
+		return target
+	}
+
+	original := process("delivery-1")
+	duplicate := process("delivery-1")
+
+	if !duplicate.Duplicate() || duplicate.EventID() != original.EventID() {
+		t.Fatalf("expected the duplicate of event %s, got %s", original.EventID(), duplicate.EventID())
+	}
+
+	if duplicate.CurrentState() != StateManualAdd || duplicate.LastResultStatus() != ResultStatusOk {
+		t.Fatalf("expected the original outcome, got %s, %s", duplicate.CurrentState(), duplicate.LastResultStatus())
+	}
+
+	if firstExecutor.calls != 1 || waitExecutor.calls != 0 {
+		t.Fatalf("expected the states executed once, got %d, %d", firstExecutor.calls, waitExecutor.calls)
+	}
+
+	if next := process("delivery-2"); next.Duplicate() || waitExecutor.calls != 1 {
+		t.Fatal("expected the event with the new key processed")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	if expired := process("delivery-1"); expired.Duplicate() || waitExecutor.calls != 2 {
+		t.Fatal("expected the event with the expired key processed")
+	}
+
+	// the other expired keys are left to FSM.ExpireIdempotencyKeys
+	if _, err = store.GetEventByIdempotencyKey(ctx, ed.ID(), "delivery-2"); err != nil {
+		t.Fatalf("expected the other expired key kept, got %v", err)
+	}
+}
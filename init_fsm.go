@@ -11,56 +11,62 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
-func initDB[T comparable](cfg *Config[T]) (*sqlx.DB, error) {
-	dbConn, err := createDBConn(cfg)
+func initStorage[T comparable](cfg *Config[T]) (*storage, error) {
+	dbConn, err := initDB(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("createDBConn failed: %w", err)
+		return nil, fmt.Errorf("initDB failed: %w", err)
 	}
 
-	if err = createSchema(cfg.DBConf, cfg.AppLabel); err != nil {
-		return nil, fmt.Errorf("createSchema failed: %w", err)
+	rdb, err := initRedis(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("initRedis failed: %w", err)
 	}
 
-	cfg.DBConf = fmt.Sprintf("%s search_path=%s", cfg.DBConf, searchPath)
-
-	if err = dbConn.Close(); err != nil {
-		return nil, fmt.Errorf("dbConn.Close failed: %w", err)
+	db := newDBStore(dbConn)
+	if cfg.TracerProvider != nil {
+		db.tracer, rdb.tracer = newTracer(cfg.TracerProvider), newTracer(cfg.TracerProvider)
 	}
 
-	dbConn, err = createDBConn(cfg)
-	if err != nil {
-		return nil, fmt.Errorf("createDBConn failed: %w", err)
+	return newStorage(cfg.Logger, cfg.AppLabel, db, rdb, cfg.schema(), cfg.TenantMode), nil
+}
+
+func initDB[T comparable](cfg *Config[T]) (*sqlx.DB, error) {
+	dbConn := cfg.dbPool()
+	if dbConn == nil {
+		var err error
+		if dbConn, err = createDBConn(cfg); err != nil {
+			return nil, fmt.Errorf("createDBConn failed: %w", err)
+		}
 	}
 
-	if err = Migrate(dbConn); err != nil {
-		return nil, fmt.Errorf("Migrate failed: %w", err)
+	// the schemas of the tenants are created on first use
+	if cfg.TenantMode {
+		return dbConn, nil
 	}
 
-	return dbConn, nil
-}
+	schema := cfg.schema()
+	if err := createSchema(context.Background(), dbConn, schema.Name); err != nil {
+		return nil, fmt.Errorf("createSchema failed: %w", err)
+	}
 
-func createSchema(dbConfig, appLabel string) error {
-	connConfig, err := pgx.ParseConfig(dbConfig)
-	if err != nil {
-		return err
+	if err := MigrateUp(dbConn, schema); err != nil {
+		return nil, fmt.Errorf("MigrateUp failed: %w", err)
 	}
 
-	connConfig.RuntimeParams["application_name"] = appLabel
-	connStr := stdlib.RegisterConnConfig(connConfig)
+	return dbConn, nil
+}
 
-	db, err := sqlx.Connect("pgx", connStr)
-	if err != nil {
-		return err
-	}
+func createSchema(ctx context.Context, db *sqlx.DB, schema string) error {
+	quoted := pgx.Identifier{schema}.Sanitize()
 
-	if _, err = db.Exec(
-		fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", searchPath),
+	if _, err := db.ExecContext(ctx,
+		fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", quoted),
 	); err != nil {
 		return err
 	}
 
-	if _, err = db.Exec(
-		fmt.Sprintf("CREATE EXTENSION IF NOT EXISTS \"uuid-ossp\" SCHEMA %s", searchPath),
+	if _, err := db.ExecContext(ctx,
+		fmt.Sprintf("CREATE EXTENSION IF NOT EXISTS \"uuid-ossp\" SCHEMA %s", quoted),
 	); err != nil {
 		return err
 	}
@@ -100,10 +106,13 @@ func initRedis[T comparable](cfg *Config[T]) (*rClient, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), connectTimeLimit)
 	defer cancel()
 
-	client := redis.NewClient(&redis.Options{
-		Addr:     cfg.RedisConf.URL,
-		Password: cfg.RedisConf.Password,
-	})
+	client := cfg.RedisClient
+	if client == nil {
+		client = redis.NewUniversalClient(&redis.UniversalOptions{
+			Addrs:    []string{cfg.RedisConf.URL},
+			Password: cfg.RedisConf.Password,
+		})
+	}
 
 	if err := client.Ping(ctx).Err(); err != nil {
 		return nil, fmt.Errorf("redis.Ping failed: %w", err)
@@ -0,0 +1,181 @@
+package event_fsm
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LockMode is the behaviour of ProcessEvent when the target is processed by another call
+type LockMode int
+
+const (
+	// LockModeWait waits until the target is released
+	LockModeWait LockMode = iota
+
+	// LockModeFailFast returns ErrTargetBusy
+	LockModeFailFast
+
+	// LockModeEnqueue returns ErrEventEnqueued and processes the target in background once it is released.
+	// The enqueued events of one target are processed in the order they came in.
+	// They are kept in the memory of the process and are lost on crash or restart,
+	// use FSM.Enqueue and Worker for the durable queue.
+	LockModeEnqueue
+)
+
+// Locker serializes processing of the events of one target
+type Locker interface {
+	// Lock acquires the lock of the key, waiting until it is released or ctx is done
+	Lock(ctx context.Context, key string) (Lock, error)
+
+	// TryLock acquires the lock of the key, ErrTargetBusy is returned if it is held
+	TryLock(ctx context.Context, key string) (Lock, error)
+}
+
+// Lock is an acquired lock of the key
+type Lock interface {
+	// Token is the fencing token, it grows with every acquisition of the key.
+	// The tokens of MemoryLocker and RedisLocker are the microseconds of the clock kept growing by the counter,
+	// so they are comparable: the Store accepts the writes after the lockers are switched or the counter
+	// of Redis is reset. The writes are rejected with ErrLockLost only while the clock is behind the tokens
+	// recorded by the Store, e.g. it was set back.
+	Token() int64
+
+	// Lost is closed when the lock is lost before Unlock, e.g. the lease could not be renewed
+	Lost() <-chan struct{}
+
+	// Unlock releases the lock
+	Unlock(ctx context.Context) error
+}
+
+type lockTokenKey struct{}
+
+// LockToken returns the fencing token of the target lock held by the FSM.
+// It is available in the context passed to executors, TargetData.Save and the writes of the Store,
+// and can be used to reject writes of the processing which lost its lock.
+func LockToken(ctx context.Context) (int64, bool) {
+	token, ok := ctx.Value(lockTokenKey{}).(int64)
+	return token, ok
+}
+
+func withLockToken(ctx context.Context, token int64) context.Context {
+	return context.WithValue(ctx, lockTokenKey{}, token)
+}
+
+var _ Locker = (*MemoryLocker)(nil)
+
+// MemoryLocker is the Locker for targets processed by a single process
+type MemoryLocker struct {
+	mu    sync.Mutex
+	locks map[string]*memoryLockEntry
+
+	token atomic.Int64
+}
+
+type memoryLockEntry struct {
+	sem  chan struct{}
+	refs int
+}
+
+func NewMemoryLocker() *MemoryLocker {
+	return &MemoryLocker{
+		locks: make(map[string]*memoryLockEntry),
+	}
+}
+
+// nextLockToken returns the microseconds of the clock, or the last token plus one if the clock is behind it
+func nextLockToken(last *atomic.Int64) int64 {
+	for {
+		prev := last.Load()
+
+		token := max(prev+1, time.Now().UnixMicro())
+		if last.CompareAndSwap(prev, token) {
+			return token
+		}
+	}
+}
+
+func (l *MemoryLocker) Lock(ctx context.Context, key string) (Lock, error) {
+	e := l.acquireEntry(key)
+
+	select {
+	case e.sem <- struct{}{}:
+		return l.newLock(key, e), nil
+	case <-ctx.Done():
+		l.releaseEntry(key, e)
+		return nil, ctx.Err()
+	}
+}
+
+func (l *MemoryLocker) TryLock(_ context.Context, key string) (Lock, error) {
+	e := l.acquireEntry(key)
+
+	select {
+	case e.sem <- struct{}{}:
+		return l.newLock(key, e), nil
+	default:
+		l.releaseEntry(key, e)
+		return nil, ErrTargetBusy
+	}
+}
+
+func (l *MemoryLocker) newLock(key string, e *memoryLockEntry) *memoryLock {
+	return &memoryLock{
+		locker: l,
+		key:    key,
+		entry:  e,
+		token:  nextLockToken(&l.token),
+	}
+}
+
+func (l *MemoryLocker) acquireEntry(key string) *memoryLockEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.locks[key]
+	if !ok {
+		e = &memoryLockEntry{sem: make(chan struct{}, 1)}
+		l.locks[key] = e
+	}
+	e.refs++
+
+	return e
+}
+
+func (l *MemoryLocker) releaseEntry(key string, e *memoryLockEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e.refs--
+	if e.refs == 0 {
+		delete(l.locks, key)
+	}
+}
+
+type memoryLock struct {
+	locker *MemoryLocker
+	key    string
+	entry  *memoryLockEntry
+	token  int64
+
+	once sync.Once
+}
+
+func (l *memoryLock) Token() int64 {
+	return l.token
+}
+
+// Lost returns nil channel, the in-process lock can't be lost
+func (l *memoryLock) Lost() <-chan struct{} {
+	return nil
+}
+
+func (l *memoryLock) Unlock(_ context.Context) error {
+	l.once.Do(func() {
+		<-l.entry.sem
+		l.locker.releaseEntry(l.key, l.entry)
+	})
+
+	return nil
+}
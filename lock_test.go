@@ -0,0 +1,177 @@
+package event_fsm
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type stateBlocking struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *stateBlocking) Execute(ctx context.Context, data *eventData) (ResultStatus, error) {
+	if _, ok := LockToken(ctx); !ok {
+		return ResultStatusFail, errors.New("no lock token in context")
+	}
+
+	select {
+	case s.started <- struct{}{}:
+	default:
+	}
+
+	<-s.release
+
+	return ResultStatusOk, nil
+}
+
+func newLockTestFSM(t *testing.T, mode LockMode) (*FSM[*eventData], *stateBlocking) {
+	blocking := &stateBlocking{started: make(chan struct{}, 1), release: make(chan struct{})}
+
+	sd := NewStateDetector[*eventData]()
+	add3 := sd.NewState(StateAdd3, blocking, StateTypeTransition)
+	manualAdd := sd.NewState(StateManualAdd, &stateManualAdd{cache: newCache()}, StateTypeWaitEvent)
+	sd.SetMainState(StateAdd3)
+
+	add3.SetNext(manualAdd, ResultStatusOk)
+	manualAdd.SetNext(add3, ResultStatusOk)
+
+	fsm, err := NewFSM(&Config[*eventData]{
+		Logger:        zap.NewNop(),
+		StateDetector: sd,
+		Store:         NewMemoryStore(),
+		LockMode:      mode,
+	})
+	if err != nil {
+		t.Fatalf("NewFSM: %v", err)
+	}
+
+	return fsm, blocking
+}
+
+func TestProcessEventFailFast(t *testing.T) {
+	fsm, blocking := newLockTestFSM(t, LockModeFailFast)
+
+	first := newEventData(1)
+	done := make(chan error)
+	go func() {
+		_, err := fsm.ProcessEvent(context.Background(), NewTarget(&first))
+		done <- err
+	}()
+	<-blocking.started
+
+	second := newEventData(1)
+	if _, err := fsm.ProcessEvent(context.Background(), NewTarget(&second)); !errors.Is(err, ErrTargetBusy) {
+		t.Fatalf("expected ErrTargetBusy, got %v", err)
+	}
+
+	close(blocking.release)
+	if err := <-done; err != nil {
+		t.Fatalf("ProcessEvent: %v", err)
+	}
+}
+
+func TestProcessEventEnqueue(t *testing.T) {
+	fsm, blocking := newLockTestFSM(t, LockModeEnqueue)
+
+	first := newEventData(1)
+	done := make(chan error)
+	go func() {
+		_, err := fsm.ProcessEvent(context.Background(), NewTarget(&first))
+		done <- err
+	}()
+	<-blocking.started
+
+	second := newEventData(1)
+	second.StateName = StateManualAdd
+	if _, err := fsm.ProcessEvent(context.Background(), NewTarget(&second)); !errors.Is(err, ErrEventEnqueued) {
+		t.Fatalf("expected ErrEventEnqueued, got %v", err)
+	}
+
+	close(blocking.release)
+	if err := <-done; err != nil {
+		t.Fatalf("ProcessEvent: %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		page, err := fsm.Events(context.Background(), first.ID(), EventsFilter{})
+		if err != nil {
+			t.Fatalf("Events: %v", err)
+		}
+
+		if len(page.Events) == 2 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("enqueued event is not processed, events: %d", len(page.Events))
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestStaleLockTokenRejected(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	// the lock of the first holder has expired, the next holder has written with the greater token
+	if _, err := store.SaveEvent(withLockToken(ctx, 2), Event{TargetID: "1"}); err != nil {
+		t.Fatalf("SaveEvent: %v", err)
+	}
+
+	if _, err := store.CreateFullLog(withLockToken(ctx, 1), Log{TargetID: "1"}); !errors.Is(err, ErrLockLost) {
+		t.Fatalf("expected ErrLockLost, got %v", err)
+	}
+
+	if _, err := store.SaveEvent(withLockToken(ctx, 1), Event{TargetID: "2"}); err != nil {
+		t.Fatalf("SaveEvent of another target: %v", err)
+	}
+}
+
+func TestLockTokensGrow(t *testing.T) {
+	var last atomic.Int64
+
+	// the clock is behind the tokens recorded before, e.g. by the other locker
+	last.Store(time.Now().Add(time.Hour).UnixMicro())
+
+	prev := last.Load()
+	for i := 0; i < 3; i++ {
+		token := nextLockToken(&last)
+		if token <= prev {
+			t.Fatalf("expected the token greater than %d, got %d", prev, token)
+		}
+
+		prev = token
+	}
+
+	last.Store(0)
+	if token := nextLockToken(&last); token < time.Now().Add(-time.Minute).UnixMicro() {
+		t.Fatalf("expected the token of the clock after the reset, got %d", token)
+	}
+}
+
+func TestPendingEventsTenants(t *testing.T) {
+	tenantA, tenantB := WithTenant(context.Background(), "a"), WithTenant(context.Background(), "b")
+	pending := newPendingEvents[*eventData]()
+
+	ed := newEventData(1)
+	if !pending.push(tenantA, lockKey(tenantA, ed.ID()), NewTarget(&ed)) {
+		t.Fatal("expected the queue of the target created")
+	}
+
+	// the same target ID of another tenant is not held back by the queue of the first tenant
+	if pending.appendIfPending(tenantB, lockKey(tenantB, ed.ID()), NewTarget(&ed)) {
+		t.Fatal("expected the event of another tenant not enqueued")
+	}
+
+	if !pending.appendIfPending(tenantA, lockKey(tenantA, ed.ID()), NewTarget(&ed)) {
+		t.Fatal("expected the event of the same tenant enqueued")
+	}
+}
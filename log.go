@@ -10,18 +10,26 @@ type Log struct {
 	EventID             string
 	CurrentStateName    StateName
 	CurrentResultStatus ResultStatus
+	Error               string // error of the executor, empty if it succeeded
+	Attempt             int    // number of the execution of the state, starting from 1
+	TraceID             string // trace of the execution, see Config.TracerProvider
+	SpanID              string // span of the execution, see Config.TracerProvider
 	CreatedAt           time.Time
 	UpdatedAt           time.Time
 }
 
 type logDto struct {
-	ID            string       `db:"id" json:"id"`
-	TargetID      string       `db:"target_id" json:"target_id"`
-	EventID       string       `db:"event_id" json:"event_id"`
-	CurrentState  StateName    `db:"current_state" json:"current_state"`
-	CurrentResult ResultStatus `db:"current_result_status" json:"current_result_status"`
-	CreatedAt     time.Time    `db:"created_at" json:"created_at"`
-	UpdatedAt     time.Time    `db:"updated_at" json:"updated_at"`
+	ID            string    `db:"id" json:"id"`
+	TargetID      string    `db:"target_id" json:"target_id"`
+	EventID       string    `db:"event_id" json:"event_id"`
+	CurrentState  string    `db:"current_state" json:"current_state"`
+	CurrentResult string    `db:"current_result_status" json:"current_result_status"`
+	Error         string    `db:"error" json:"error,omitempty"`
+	Attempt       int       `db:"attempt" json:"attempt"`
+	TraceID       string    `db:"trace_id" json:"trace_id,omitempty"`
+	SpanID        string    `db:"span_id" json:"span_id,omitempty"`
+	CreatedAt     time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt     time.Time `db:"updated_at" json:"updated_at"`
 }
 
 func logToDTO(log Log) logDto {
@@ -29,8 +37,12 @@ func logToDTO(log Log) logDto {
 		ID:            log.ID,
 		TargetID:      log.TargetID,
 		EventID:       log.EventID,
-		CurrentState:  log.CurrentStateName,
-		CurrentResult: log.CurrentResultStatus,
+		CurrentState:  log.CurrentStateName.String(),
+		CurrentResult: log.CurrentResultStatus.String(),
+		Error:         log.Error,
+		Attempt:       log.Attempt,
+		TraceID:       log.TraceID,
+		SpanID:        log.SpanID,
 		CreatedAt:     log.CreatedAt,
 		UpdatedAt:     log.UpdatedAt,
 	}
@@ -41,8 +53,12 @@ func (l *logDto) toLog() Log {
 		ID:                  l.ID,
 		TargetID:            l.TargetID,
 		EventID:             l.EventID,
-		CurrentStateName:    l.CurrentState,
-		CurrentResultStatus: l.CurrentResult,
+		CurrentStateName:    StateName(l.CurrentState),
+		CurrentResultStatus: ResultStatus(l.CurrentResult),
+		Error:               l.Error,
+		Attempt:             l.Attempt,
+		TraceID:             l.TraceID,
+		SpanID:              l.SpanID,
 		CreatedAt:           l.CreatedAt,
 		UpdatedAt:           l.UpdatedAt,
 	}
@@ -0,0 +1,729 @@
+package event_fsm
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	_ Store          = (*MemoryStore)(nil)
+	_ SchedulerStore = (*MemoryStore)(nil)
+	_ RecoveryStore  = (*MemoryStore)(nil)
+
+	_ IdempotencyStore = (*MemoryStore)(nil)
+	_ TargetStore      = (*MemoryStore)(nil)
+	_ OutboxStore      = (*MemoryStore)(nil)
+	_ QueueStore       = (*MemoryStore)(nil)
+	_ DeadLetterStore  = (*MemoryStore)(nil)
+)
+
+// MemoryStore is the Store that keeps events and logs in memory.
+// It is safe for concurrent use and is intended for tests and single-process deployments.
+type MemoryStore struct {
+	mu sync.RWMutex
+
+	events         map[string]Event
+	targetEventIDs map[string][]string
+
+	logs         map[string]Log
+	targetLogIDs map[string][]string
+
+	scheduled map[string]memoryScheduledEvent
+
+	targets map[string]TargetState
+
+	outbox    map[string]memoryOutboxEntry
+	outboxSeq int64
+
+	queue    map[string]memoryQueuedEvent
+	queueSeq int64
+
+	deadLetters map[string]DeadLetter
+
+	// fences are the greatest lock tokens of the targets, see LockToken
+	fences map[string]int64
+}
+
+type memoryScheduledEvent struct {
+	ScheduledEvent
+	lockedUntil time.Time
+}
+
+type memoryOutboxEntry struct {
+	OutboxEntry
+	lockedUntil time.Time
+}
+
+type memoryQueuedEvent struct {
+	QueuedEvent
+	seq         int64
+	lockedUntil time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		events:         make(map[string]Event),
+		targetEventIDs: make(map[string][]string),
+		logs:           make(map[string]Log),
+		targetLogIDs:   make(map[string][]string),
+		scheduled:      make(map[string]memoryScheduledEvent),
+		targets:        make(map[string]TargetState),
+		outbox:         make(map[string]memoryOutboxEntry),
+		queue:          make(map[string]memoryQueuedEvent),
+		deadLetters:    make(map[string]DeadLetter),
+		fences:         make(map[string]int64),
+	}
+}
+
+func (s *MemoryStore) SaveEvent(ctx context.Context, event Event) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fence(ctx, event.TargetID); err != nil {
+		return "", err
+	}
+
+	if event.ID == "" {
+		event.ID = uuid.NewString()
+	}
+
+	if _, ok := s.events[event.ID]; ok {
+		return "", fmt.Errorf("event %s already exists", event.ID)
+	}
+
+	if event.IdempotencyKey != "" {
+		if _, ok := s.eventByIdempotencyKey(event.TargetID, event.IdempotencyKey); ok {
+			return "", fmt.Errorf("event with idempotency key %s already exists", event.IdempotencyKey)
+		}
+	}
+
+	now := time.Now()
+	event.CreatedAt = now
+	event.UpdatedAt = now
+	s.events[event.ID] = event
+	s.targetEventIDs[event.TargetID] = append(s.targetEventIDs[event.TargetID], event.ID)
+
+	return event.ID, nil
+}
+
+func (s *MemoryStore) UpdateEvent(ctx context.Context, event Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fence(ctx, event.TargetID); err != nil {
+		return err
+	}
+
+	e, ok := s.events[event.ID]
+	if !ok {
+		return nil
+	}
+
+	e.CurrentState = event.CurrentState
+	e.LastResultStatus = event.LastResultStatus
+	e.UpdatedAt = time.Now()
+	s.events[event.ID] = e
+
+	return nil
+}
+
+func (s *MemoryStore) GetEvent(_ context.Context, id string) (Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	e, ok := s.events[id]
+	if !ok {
+		return Event{}, ErrLastLogNotFound
+	}
+
+	return e, nil
+}
+
+func (s *MemoryStore) GetTargetState(_ context.Context, targetID string) (TargetState, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	state, ok := s.targets[targetID]
+	if !ok {
+		return TargetState{}, ErrTargetNotFound
+	}
+
+	return state, nil
+}
+
+func (s *MemoryStore) SwapTargetState(ctx context.Context, state TargetState) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fence(ctx, state.TargetID); err != nil {
+		return 0, err
+	}
+
+	// the missing target has version 0
+	if s.targets[state.TargetID].Version != state.Version {
+		return 0, ErrConcurrentModification
+	}
+
+	state.Version++
+	state.UpdatedAt = time.Now()
+	s.targets[state.TargetID] = state
+
+	return state.Version, nil
+}
+
+// fence records the lock token of the context for the target,
+// the write with the token less than the recorded one is rejected with ErrLockLost
+func (s *MemoryStore) fence(ctx context.Context, targetID string) error {
+	token, ok := LockToken(ctx)
+	if !ok {
+		return nil
+	}
+
+	if token < s.fences[targetID] {
+		return fmt.Errorf("lock token %d of target %s is stale: %w", token, targetID, ErrLockLost)
+	}
+
+	s.fences[targetID] = token
+
+	return nil
+}
+
+func (s *MemoryStore) GetEventByIdempotencyKey(_ context.Context, targetID, key string) (Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	e, ok := s.eventByIdempotencyKey(targetID, key)
+	if !ok {
+		return Event{}, ErrLastLogNotFound
+	}
+
+	return e, nil
+}
+
+func (s *MemoryStore) eventByIdempotencyKey(targetID, key string) (Event, bool) {
+	for _, id := range s.targetEventIDs[targetID] {
+		if e := s.events[id]; e.IdempotencyKey == key {
+			return e, true
+		}
+	}
+
+	return Event{}, false
+}
+
+func (s *MemoryStore) ExpireIdempotencyKeys(_ context.Context, olderThan time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deadline := time.Now().Add(-olderThan)
+
+	for id, e := range s.events {
+		if e.IdempotencyKey != "" && e.CreatedAt.Before(deadline) {
+			e.IdempotencyKey = ""
+			s.events[id] = e
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) ExpireIdempotencyKey(_ context.Context, targetID, key string, olderThan time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.eventByIdempotencyKey(targetID, key)
+	if ok && e.CreatedAt.Before(time.Now().Add(-olderThan)) {
+		e.IdempotencyKey = ""
+		s.events[e.ID] = e
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) SaveLog(ctx context.Context, log Log) (string, error) {
+	log.CurrentResultStatus = ResultStatusEmpty
+
+	return s.CreateFullLog(ctx, log)
+}
+
+func (s *MemoryStore) CreateFullLog(ctx context.Context, log Log) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fence(ctx, log.TargetID); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	log.ID = uuid.NewString()
+	log.CreatedAt = now
+	log.UpdatedAt = now
+
+	s.logs[log.ID] = log
+	s.targetLogIDs[log.TargetID] = append(s.targetLogIDs[log.TargetID], log.ID)
+
+	return log.ID, nil
+}
+
+func (s *MemoryStore) UpdateLog(ctx context.Context, log Log) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fence(ctx, log.TargetID); err != nil {
+		return err
+	}
+
+	l, ok := s.logs[log.ID]
+	if !ok {
+		return nil
+	}
+
+	l.CurrentResultStatus = log.CurrentResultStatus
+	l.Error = log.Error
+	l.UpdatedAt = time.Now()
+	s.logs[log.ID] = l
+
+	return nil
+}
+
+func (s *MemoryStore) GetEvents(_ context.Context, targetID string, filter EventsFilter) (EventPage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := s.targetEventIDs[targetID]
+	events := make([]Event, 0, len(ids))
+	for _, id := range ids {
+		events = append(events, s.events[id])
+	}
+
+	events, next, err := memoryPage(
+		events, func(e Event) (time.Time, string) { return e.CreatedAt, e.ID },
+		filter.From, filter.To, filter.Cursor, historyLimit(filter.Limit),
+	)
+	if err != nil {
+		return EventPage{}, err
+	}
+
+	return EventPage{Events: events, NextCursor: next}, nil
+}
+
+func (s *MemoryStore) GetLogs(_ context.Context, targetID string, filter HistoryFilter) (LogPage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := s.targetLogIDs[targetID]
+	logs := make([]Log, 0, len(ids))
+	for _, id := range ids {
+		l := s.logs[id]
+		if len(filter.States) > 0 && !slices.Contains(filter.States, l.CurrentStateName) {
+			continue
+		}
+
+		logs = append(logs, l)
+	}
+
+	logs, next, err := memoryPage(
+		logs, func(l Log) (time.Time, string) { return l.CreatedAt, l.ID },
+		filter.From, filter.To, filter.Cursor, historyLimit(filter.Limit),
+	)
+	if err != nil {
+		return LogPage{}, err
+	}
+
+	return LogPage{Logs: logs, NextCursor: next}, nil
+}
+
+// memoryPage sorts the records newest first and cuts the page after the cursor,
+// the same way the Postgres storage does
+func memoryPage[R any](
+	records []R, key func(R) (time.Time, string), from, to time.Time, cursor string, limit int,
+) ([]R, string, error) {
+	cursorTime, cursorID, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	records = slices.DeleteFunc(records, func(r R) bool {
+		createdAt, id := key(r)
+
+		if !from.IsZero() && createdAt.Before(from) {
+			return true
+		}
+
+		if !to.IsZero() && !createdAt.Before(to) {
+			return true
+		}
+
+		return cursorID != "" && compareKeys(createdAt, id, cursorTime, cursorID) >= 0
+	})
+
+	slices.SortFunc(records, func(a, b R) int {
+		aTime, aID := key(a)
+		bTime, bID := key(b)
+
+		return compareKeys(bTime, bID, aTime, aID)
+	})
+
+	if len(records) <= limit {
+		return records, "", nil
+	}
+
+	records = records[:limit]
+	lastTime, lastID := key(records[limit-1])
+
+	return records, encodeCursor(lastTime, lastID), nil
+}
+
+func compareKeys(aTime time.Time, aID string, bTime time.Time, bID string) int {
+	if c := aTime.Compare(bTime); c != 0 {
+		return c
+	}
+
+	return strings.Compare(aID, bID)
+}
+
+// DeleteLogs deletes logs older than the specified duration,
+// keeping the last 'keepCount' of them for each target.
+func (s *MemoryStore) DeleteLogs(_ context.Context, olderThan time.Duration, keepCount int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deadline := time.Now().Add(-olderThan)
+
+	for targetID, ids := range s.targetLogIDs {
+		// ids are in insertion order, so the newest old logs are at the end
+		kept := make([]string, 0, len(ids))
+		oldCount := 0
+		for i := len(ids) - 1; i >= 0; i-- {
+			l := s.logs[ids[i]]
+			if l.CreatedAt.Before(deadline) {
+				oldCount++
+				if oldCount > keepCount {
+					delete(s.logs, l.ID)
+					continue
+				}
+			}
+
+			kept = append(kept, l.ID)
+		}
+
+		if len(kept) == 0 {
+			delete(s.targetLogIDs, targetID)
+			continue
+		}
+
+		slices.Reverse(kept)
+		s.targetLogIDs[targetID] = kept
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) ClaimAbandonedLogs(_ context.Context, olderThan time.Duration, limit int) ([]Log, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	deadline := now.Add(-olderThan)
+
+	var logs []Log
+	for _, l := range s.logs {
+		if l.CurrentResultStatus == ResultStatusEmpty && l.CreatedAt.Before(deadline) {
+			logs = append(logs, l)
+		}
+	}
+
+	slices.SortFunc(logs, func(a, b Log) int {
+		return compareKeys(a.CreatedAt, a.ID, b.CreatedAt, b.ID)
+	})
+
+	logs = logs[:min(len(logs), limit)]
+	for i := range logs {
+		logs[i].CurrentResultStatus = ResultStatusAbandoned
+		logs[i].Error = abandonedLogError
+		logs[i].UpdatedAt = now
+		s.logs[logs[i].ID] = logs[i]
+	}
+
+	return logs, nil
+}
+
+func (s *MemoryStore) SaveScheduledEvent(_ context.Context, event ScheduledEvent) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	event.ID = uuid.NewString()
+	event.Attempts = 0
+	event.CreatedAt = time.Now()
+	s.scheduled[event.ID] = memoryScheduledEvent{ScheduledEvent: event}
+
+	return event.ID, nil
+}
+
+func (s *MemoryStore) DeleteScheduledEvent(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.scheduled, id)
+
+	return nil
+}
+
+func (s *MemoryStore) DeleteBoundScheduledEvents(_ context.Context, targetID string, state StateName) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, e := range s.scheduled {
+		if e.TargetID == targetID && e.State == state {
+			delete(s.scheduled, id)
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) ClaimScheduledEvents(_ context.Context, limit int, lease time.Duration) ([]ScheduledEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	var due []memoryScheduledEvent
+	for _, e := range s.scheduled {
+		if !e.RunAt.After(now) && !e.lockedUntil.After(now) {
+			due = append(due, e)
+		}
+	}
+
+	slices.SortFunc(due, func(a, b memoryScheduledEvent) int {
+		return a.RunAt.Compare(b.RunAt)
+	})
+
+	events := make([]ScheduledEvent, 0, min(len(due), limit))
+	for _, e := range due[:min(len(due), limit)] {
+		e.Attempts++
+		e.lockedUntil = now.Add(lease)
+		s.scheduled[e.ID] = e
+
+		events = append(events, e.ScheduledEvent)
+	}
+
+	return events, nil
+}
+
+func (s *MemoryStore) SaveOutboxEntry(_ context.Context, entry OutboxEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.outboxSeq++
+
+	entry.ID = uuid.NewString()
+	entry.Seq = s.outboxSeq
+	entry.Attempts = 0
+	entry.CreatedAt = time.Now()
+	s.outbox[entry.ID] = memoryOutboxEntry{OutboxEntry: entry}
+
+	return nil
+}
+
+func (s *MemoryStore) ClaimOutboxEntries(_ context.Context, limit int, lease time.Duration) ([]OutboxEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	// the oldest entries of the targets
+	heads := make(map[string]memoryOutboxEntry)
+	for _, e := range s.outbox {
+		if head, ok := heads[e.TargetID]; !ok || e.Seq < head.Seq {
+			heads[e.TargetID] = e
+		}
+	}
+
+	var free []memoryOutboxEntry
+	for _, head := range heads {
+		if !head.lockedUntil.After(now) {
+			free = append(free, head)
+		}
+	}
+
+	slices.SortFunc(free, func(a, b memoryOutboxEntry) int {
+		return cmp.Compare(a.Seq, b.Seq)
+	})
+
+	claimed := make(map[string]bool)
+	for _, head := range free[:min(len(free), limit)] {
+		claimed[head.TargetID] = true
+	}
+
+	var entries []OutboxEntry
+	for id, e := range s.outbox {
+		if !claimed[e.TargetID] {
+			continue
+		}
+
+		e.Attempts++
+		e.lockedUntil = now.Add(lease)
+		s.outbox[id] = e
+
+		entries = append(entries, e.OutboxEntry)
+	}
+
+	slices.SortFunc(entries, func(a, b OutboxEntry) int {
+		return cmp.Compare(a.Seq, b.Seq)
+	})
+
+	return entries, nil
+}
+
+func (s *MemoryStore) DeleteOutboxEntries(_ context.Context, ids []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		delete(s.outbox, id)
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) EnqueueEvent(_ context.Context, event QueuedEvent) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.queueSeq++
+
+	event.ID = uuid.NewString()
+	event.Attempts = 0
+	event.CreatedAt = time.Now()
+	s.queue[event.ID] = memoryQueuedEvent{QueuedEvent: event, seq: s.queueSeq}
+
+	return event.ID, nil
+}
+
+func (s *MemoryStore) ClaimQueuedEvents(_ context.Context, limit int, visibility time.Duration) ([]QueuedEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	// the oldest events of the targets
+	heads := make(map[string]memoryQueuedEvent)
+	for _, e := range s.queue {
+		if head, ok := heads[e.TargetID]; !ok || e.seq < head.seq {
+			heads[e.TargetID] = e
+		}
+	}
+
+	var free []memoryQueuedEvent
+	for _, head := range heads {
+		if !head.lockedUntil.After(now) {
+			free = append(free, head)
+		}
+	}
+
+	slices.SortFunc(free, func(a, b memoryQueuedEvent) int {
+		return cmp.Compare(a.seq, b.seq)
+	})
+
+	claimed := make(map[string]bool)
+	for _, head := range free[:min(len(free), limit)] {
+		claimed[head.TargetID] = true
+	}
+
+	var events []memoryQueuedEvent
+	for id, e := range s.queue {
+		if !claimed[e.TargetID] {
+			continue
+		}
+
+		if e.seq == heads[e.TargetID].seq {
+			e.Attempts++
+		}
+
+		e.lockedUntil = now.Add(visibility)
+		s.queue[id] = e
+
+		events = append(events, e)
+	}
+
+	slices.SortFunc(events, func(a, b memoryQueuedEvent) int {
+		return cmp.Compare(a.seq, b.seq)
+	})
+
+	result := make([]QueuedEvent, 0, len(events))
+	for _, e := range events {
+		result = append(result, e.QueuedEvent)
+	}
+
+	return result, nil
+}
+
+func (s *MemoryStore) DeleteQueuedEvent(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.queue, id)
+
+	return nil
+}
+
+func (s *MemoryStore) SaveDeadLetter(_ context.Context, dl DeadLetter) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dl.ID = uuid.NewString()
+	dl.CreatedAt = time.Now()
+	s.deadLetters[dl.ID] = dl
+
+	return dl.ID, nil
+}
+
+func (s *MemoryStore) GetDeadLetter(_ context.Context, id string) (DeadLetter, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	dl, ok := s.deadLetters[id]
+	if !ok {
+		return DeadLetter{}, ErrDeadLetterNotFound
+	}
+
+	return dl, nil
+}
+
+func (s *MemoryStore) GetDeadLetters(_ context.Context, filter DeadLetterFilter) (DeadLetterPage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	dls := make([]DeadLetter, 0, len(s.deadLetters))
+	for _, dl := range s.deadLetters {
+		if filter.TargetID == "" || dl.TargetID == filter.TargetID {
+			dls = append(dls, dl)
+		}
+	}
+
+	dls, next, err := memoryPage(
+		dls, func(dl DeadLetter) (time.Time, string) { return dl.CreatedAt, dl.ID },
+		filter.From, filter.To, filter.Cursor, historyLimit(filter.Limit),
+	)
+	if err != nil {
+		return DeadLetterPage{}, err
+	}
+
+	return DeadLetterPage{DeadLetters: dls, NextCursor: next}, nil
+}
+
+func (s *MemoryStore) DeleteDeadLetter(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.deadLetters, id)
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package event_fsm
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreDeleteLogs(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+
+	for _, targetID := range []string{"1", "2"} {
+		for i := 0; i < 5; i++ {
+			if _, err := s.CreateFullLog(ctx, Log{TargetID: targetID, CurrentResultStatus: ResultStatusOk}); err != nil {
+				t.Fatalf("CreateFullLog: %v", err)
+			}
+		}
+	}
+
+	lastLogs, err := s.GetLogs(ctx, "1", HistoryFilter{})
+	if err != nil {
+		t.Fatalf("GetLogs: %v", err)
+	}
+
+	time.Sleep(time.Millisecond)
+
+	if err = s.DeleteLogs(ctx, 0, 2); err != nil {
+		t.Fatalf("DeleteLogs: %v", err)
+	}
+
+	for _, targetID := range []string{"1", "2"} {
+		page, err := s.GetLogs(ctx, targetID, HistoryFilter{})
+		if err != nil {
+			t.Fatalf("GetLogs: %v", err)
+		}
+
+		if len(page.Logs) != 2 {
+			t.Fatalf("expected 2 logs for target %s, got %d", targetID, len(page.Logs))
+		}
+	}
+
+	page, _ := s.GetLogs(ctx, "1", HistoryFilter{})
+	if page.Logs[0].ID != lastLogs.Logs[0].ID || page.Logs[1].ID != lastLogs.Logs[1].ID {
+		t.Fatalf("expected the newest logs to be kept")
+	}
+}
@@ -1,17 +1,23 @@
 package event_fsm
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"io"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/jackc/pgx/v5"
 	"github.com/jmoiron/sqlx"
 )
 
 const (
-	migrationPath      = "fsm_migrations/"
 	migrationTableName = "fsm_schema_migrations"
 )
 
@@ -22,80 +28,221 @@ type migrationData struct {
 	Data    string
 }
 
-// Migrate runs the migration files in the migrationPath folder.
-// It takes the database connection
+// MigrationState is the state of the FSM schema in the database
+type MigrationState struct {
+	// Version is the applied version, 0 if no migrations are applied
+	Version uint
+
+	// Latest is the latest version known to the library
+	Latest uint
+
+	// Dirty is true if the last migration failed and the schema must be fixed manually
+	Dirty bool
+}
+
+// Migrate runs all migrations up in the current schema of the connection,
+// it is kept for compatibility with MigrateUp.
 // db - sqlx database connection
 func Migrate(db *sqlx.DB) error {
-	if err := createMigrationDirWithFiles(migrationPath, migrations); err != nil {
+	return MigrateUp(db, Schema{})
+}
+
+// MigrateUp applies all migrations which are not applied yet to the schema.
+// The migrations are served from memory, nothing is written to the disk.
+// The schema must exist, NewFSM creates it.
+func MigrateUp(db *sqlx.DB, schema Schema) error {
+	return runMigration(db, schema, func(m *migrate.Migrate) error {
+		return m.Up()
+	})
+}
+
+// MigrateDown rolls back all migrations of the schema, the FSM tables are dropped
+func MigrateDown(db *sqlx.DB, schema Schema) error {
+	return runMigration(db, schema, func(m *migrate.Migrate) error {
+		return m.Down()
+	})
+}
+
+// MigrateTo migrates the schema up or down to the given version
+func MigrateTo(db *sqlx.DB, schema Schema, version uint) error {
+	return runMigration(db, schema, func(m *migrate.Migrate) error {
+		return m.Migrate(version)
+	})
+}
+
+// MigrationStatus returns the applied version of the schema
+func MigrationStatus(db *sqlx.DB, schema Schema) (MigrationState, error) {
+	src, err := newMigrationSource(migrations, schema)
+	if err != nil {
+		return MigrationState{}, err
+	}
+
+	state := MigrationState{Latest: src.latest()}
+
+	err = runMigration(db, schema, func(m *migrate.Migrate) error {
+		var err error
+		state.Version, state.Dirty, err = m.Version()
+		if errors.Is(err, migrate.ErrNilVersion) {
+			return nil
+		}
+
+		return err
+	})
+
+	return state, err
+}
+
+// runMigration runs fn with the migrate instance over the in-memory migrations.
+// The migrations of concurrent processes are serialized by the advisory lock of the postgres driver.
+func runMigration(db *sqlx.DB, schema Schema, fn func(m *migrate.Migrate) error) error {
+	ctx := context.Background()
+
+	src, err := newMigrationSource(migrations, schema)
+	if err != nil {
 		return err
 	}
 
-	migrationConfigs := &postgres.Config{
-		MigrationsTable: migrationTableName,
+	// the migrations run on the dedicated connection, which is returned to the pool at the end,
+	// so m.Close is not called: it would close the connection before search_path is reset
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("db.Conn: %w", err)
 	}
-	if err := migrateUp(db, migrationPath, migrationConfigs); err != nil {
-		return err
+	defer conn.Close()
+
+	if schema.Name != "" {
+		// the migrations address the tables of the schema by search_path
+		if _, err = conn.ExecContext(
+			ctx, "SET search_path TO "+pgx.Identifier{schema.Name}.Sanitize(),
+		); err != nil {
+			return fmt.Errorf("set search_path: %w", err)
+		}
+
+		defer func() {
+			_, _ = conn.ExecContext(ctx, "RESET search_path")
+		}()
+	}
+
+	dr, err := postgres.WithConnection(ctx, conn, &postgres.Config{
+		MigrationsTable: schema.prefix(migrationTableName),
+	})
+	if err != nil {
+		return fmt.Errorf("postgres.WithConnection: %w", err)
+	}
+
+	m, err := migrate.NewWithInstance("fsm", src, "postgres", dr)
+	if err != nil {
+		return fmt.Errorf("migrate.NewWithInstance: %w", err)
 	}
 
-	if err := removeMigrationsFiles(migrationPath); err != nil {
+	if err = fn(m); err != nil && !errors.Is(err, migrate.ErrNoChange) {
 		return err
 	}
 
 	return nil
 }
 
-// createMigrationDirWithFiles creates the migration dir and files with the given data
-func createMigrationDirWithFiles(migrationPath string, migrations []migrationData) error {
-	if err := os.Mkdir(migrationPath, 0755); err != nil && !errors.Is(err, os.ErrExist) {
-		return err
+var _ source.Driver = (*migrationSource)(nil)
+
+// migrationSource is the source driver of golang-migrate serving the migrations from memory
+type migrationSource struct {
+	versions []uint
+	up       map[uint]migrationData
+	down     map[uint]migrationData
+}
+
+// newMigrationSource creates the source of the migrations with the table names prefixed for the schema
+func newMigrationSource(migrations []migrationData, schema Schema) (*migrationSource, error) {
+	s := &migrationSource{
+		up:   make(map[uint]migrationData),
+		down: make(map[uint]migrationData),
 	}
 
 	for _, m := range migrations {
-		fileName := migrationPath + m.Version + "_" + m.Name + "." + m.Type + ".sql"
-
-		file, err := os.Create(fileName)
+		v, err := strconv.ParseUint(m.Version, 10, 64)
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("migration %s_%s: invalid version: %w", m.Version, m.Name, err)
 		}
 
-		if _, err = file.WriteString(m.Data); err != nil {
-			return err
+		version := uint(v)
+		m.Data = schema.prefix(m.Data)
+
+		switch m.Type {
+		case "up":
+			s.up[version] = m
+		case "down":
+			s.down[version] = m
+		default:
+			return nil, fmt.Errorf("migration %s_%s: invalid type %q", m.Version, m.Name, m.Type)
 		}
 
-		if err = file.Close(); err != nil {
-			return err
+		if !slices.Contains(s.versions, version) {
+			s.versions = append(s.versions, version)
 		}
 	}
 
+	slices.Sort(s.versions)
+
+	return s, nil
+}
+
+func (s *migrationSource) latest() uint {
+	if len(s.versions) == 0 {
+		return 0
+	}
+
+	return s.versions[len(s.versions)-1]
+}
+
+func (s *migrationSource) Open(_ string) (source.Driver, error) {
+	return nil, errors.New("migrationSource can't be opened by url")
+}
+
+func (s *migrationSource) Close() error {
 	return nil
 }
 
-// migrateUp runs the migration files in the given path
-func migrateUp(db *sqlx.DB, migrationPath string, pgConfig *postgres.Config) error {
-	dr, err := postgres.WithInstance(db.DB, pgConfig)
-	if err != nil {
-		return err
+func (s *migrationSource) First() (uint, error) {
+	if len(s.versions) == 0 {
+		return 0, os.ErrNotExist
 	}
 
-	m, err := migrate.NewWithDatabaseInstance("file://"+migrationPath, "postgres", dr)
-	if err != nil {
-		return err
+	return s.versions[0], nil
+}
+
+func (s *migrationSource) Prev(version uint) (uint, error) {
+	i, ok := slices.BinarySearch(s.versions, version)
+	if !ok || i == 0 {
+		return 0, os.ErrNotExist
 	}
 
-	if err = m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
-		return err
+	return s.versions[i-1], nil
+}
+
+func (s *migrationSource) Next(version uint) (uint, error) {
+	i, ok := slices.BinarySearch(s.versions, version)
+	if !ok || i == len(s.versions)-1 {
+		return 0, os.ErrNotExist
 	}
 
-	return nil
+	return s.versions[i+1], nil
 }
 
-// removeMigrationsFiles removes the migration files including directory
-func removeMigrationsFiles(migrationPath string) error {
-	if err := os.RemoveAll(migrationPath); err != nil {
-		return err
+func (s *migrationSource) ReadUp(version uint) (io.ReadCloser, string, error) {
+	return s.read(s.up, version)
+}
+
+func (s *migrationSource) ReadDown(version uint) (io.ReadCloser, string, error) {
+	return s.read(s.down, version)
+}
+
+func (s *migrationSource) read(migrations map[uint]migrationData, version uint) (io.ReadCloser, string, error) {
+	m, ok := migrations[version]
+	if !ok {
+		return nil, "", os.ErrNotExist
 	}
 
-	return nil
+	return io.NopCloser(strings.NewReader(m.Data)), m.Name, nil
 }
 
 //	type eventDto struct {
@@ -163,6 +310,353 @@ var migrations = []migrationData{
 			DROP TABLE IF EXISTS fsm_target_events;
 			DROP TABLE IF EXISTS fsm_target_logs;
 			
+			COMMIT;
+		`,
+	},
+	{
+		Version: "0002",
+		Name:    "add_log_error",
+		Type:    "up",
+		Data: `
+			ALTER TABLE fsm_target_logs ADD COLUMN IF NOT EXISTS error TEXT;
+		`,
+	},
+	{
+		Version: "0002",
+		Name:    "add_log_error",
+		Type:    "down",
+		Data: `
+			ALTER TABLE fsm_target_logs DROP COLUMN IF EXISTS error;
+		`,
+	},
+	{
+		Version: "0003",
+		Name:    "add_log_attempt",
+		Type:    "up",
+		Data: `
+			ALTER TABLE fsm_target_logs ADD COLUMN IF NOT EXISTS attempt INT NOT NULL DEFAULT 1;
+		`,
+	},
+	{
+		Version: "0003",
+		Name:    "add_log_attempt",
+		Type:    "down",
+		Data: `
+			ALTER TABLE fsm_target_logs DROP COLUMN IF EXISTS attempt;
+		`,
+	},
+	{
+		Version: "0004",
+		Name:    "create_scheduled_events",
+		Type:    "up",
+		Data: `
+			BEGIN;
+
+			CREATE TABLE IF NOT EXISTS fsm_scheduled_events (
+				id UUID PRIMARY KEY DEFAULT public.uuid_generate_v4(),
+				target_id VARCHAR NOT NULL,
+				result_status VARCHAR NOT NULL DEFAULT '',
+				payload JSONB,
+				run_at TIMESTAMPTZ NOT NULL,
+				locked_until TIMESTAMPTZ,
+				attempts INT NOT NULL DEFAULT 0,
+				created_at TIMESTAMPTZ DEFAULT now()
+			);
+
+			CREATE INDEX IF NOT EXISTS fsm_scheduled_events_run_at_idx ON fsm_scheduled_events (run_at);
+
+			COMMIT;
+		`,
+	},
+	{
+		Version: "0004",
+		Name:    "create_scheduled_events",
+		Type:    "down",
+		Data: `
+			DROP TABLE IF EXISTS fsm_scheduled_events;
+		`,
+	},
+	{
+		Version: "0005",
+		Name:    "add_scheduled_event_state",
+		Type:    "up",
+		Data: `
+			BEGIN;
+
+			ALTER TABLE fsm_scheduled_events ADD COLUMN IF NOT EXISTS event_id UUID;
+			ALTER TABLE fsm_scheduled_events ADD COLUMN IF NOT EXISTS state VARCHAR NOT NULL DEFAULT '';
+
+			CREATE INDEX IF NOT EXISTS fsm_scheduled_events_target_id_idx ON fsm_scheduled_events (target_id);
+
+			COMMIT;
+		`,
+	},
+	{
+		Version: "0005",
+		Name:    "add_scheduled_event_state",
+		Type:    "down",
+		Data: `
+			BEGIN;
+
+			DROP INDEX IF EXISTS fsm_scheduled_events_target_id_idx;
+			ALTER TABLE fsm_scheduled_events DROP COLUMN IF EXISTS state;
+			ALTER TABLE fsm_scheduled_events DROP COLUMN IF EXISTS event_id;
+
+			COMMIT;
+		`,
+	},
+	{
+		Version: "0006",
+		Name:    "add_unfinished_logs_index",
+		Type:    "up",
+		Data: `
+			CREATE INDEX IF NOT EXISTS fsm_target_logs_unfinished_idx ON fsm_target_logs (created_at)
+				WHERE current_result_status IS NULL;
+		`,
+	},
+	{
+		Version: "0006",
+		Name:    "add_unfinished_logs_index",
+		Type:    "down",
+		Data: `
+			DROP INDEX IF EXISTS fsm_target_logs_unfinished_idx;
+		`,
+	},
+	{
+		Version: "0007",
+		Name:    "add_event_idempotency_key",
+		Type:    "up",
+		Data: `
+			BEGIN;
+
+			ALTER TABLE fsm_target_events ADD COLUMN IF NOT EXISTS current_state VARCHAR NOT NULL DEFAULT '';
+			ALTER TABLE fsm_target_events ADD COLUMN IF NOT EXISTS idempotency_key VARCHAR;
+
+			CREATE UNIQUE INDEX IF NOT EXISTS fsm_target_events_idempotency_key_idx
+				ON fsm_target_events (target_id, idempotency_key) WHERE idempotency_key IS NOT NULL;
+
+			COMMIT;
+		`,
+	},
+	{
+		Version: "0007",
+		Name:    "add_event_idempotency_key",
+		Type:    "down",
+		Data: `
+			BEGIN;
+
+			DROP INDEX IF EXISTS fsm_target_events_idempotency_key_idx;
+			ALTER TABLE fsm_target_events DROP COLUMN IF EXISTS idempotency_key;
+			ALTER TABLE fsm_target_events DROP COLUMN IF EXISTS current_state;
+
+			COMMIT;
+		`,
+	},
+	{
+		Version: "0008",
+		Name:    "create_targets",
+		Type:    "up",
+		Data: `
+			CREATE TABLE IF NOT EXISTS fsm_targets (
+				target_id VARCHAR PRIMARY KEY,
+				current_state VARCHAR NOT NULL,
+				version BIGINT NOT NULL,
+				created_at TIMESTAMPTZ DEFAULT now(),
+				updated_at TIMESTAMPTZ DEFAULT now()
+			);
+		`,
+	},
+	{
+		Version: "0008",
+		Name:    "create_targets",
+		Type:    "down",
+		Data: `
+			DROP TABLE IF EXISTS fsm_targets;
+		`,
+	},
+	{
+		Version: "0009",
+		Name:    "create_outbox",
+		Type:    "up",
+		Data: `
+			BEGIN;
+
+			CREATE TABLE IF NOT EXISTS fsm_outbox (
+				seq BIGSERIAL PRIMARY KEY,
+				id UUID NOT NULL UNIQUE DEFAULT public.uuid_generate_v4(),
+				target_id VARCHAR NOT NULL,
+				event_id UUID NOT NULL,
+				log_id UUID NOT NULL,
+				state VARCHAR NOT NULL,
+				result_status VARCHAR NOT NULL DEFAULT '',
+				locked_until TIMESTAMPTZ,
+				attempts INT NOT NULL DEFAULT 0,
+				created_at TIMESTAMPTZ DEFAULT now()
+			);
+
+			CREATE INDEX IF NOT EXISTS fsm_outbox_target_id_idx ON fsm_outbox (target_id, seq);
+
+			COMMIT;
+		`,
+	},
+	{
+		Version: "0009",
+		Name:    "create_outbox",
+		Type:    "down",
+		Data: `
+			DROP TABLE IF EXISTS fsm_outbox;
+		`,
+	},
+	{
+		Version: "0010",
+		Name:    "create_queue",
+		Type:    "up",
+		Data: `
+			BEGIN;
+
+			CREATE TABLE IF NOT EXISTS fsm_queue (
+				seq BIGSERIAL PRIMARY KEY,
+				id UUID NOT NULL UNIQUE DEFAULT public.uuid_generate_v4(),
+				target_id VARCHAR NOT NULL,
+				payload JSONB,
+				idempotency_key VARCHAR NOT NULL DEFAULT '',
+				locked_until TIMESTAMPTZ,
+				attempts INT NOT NULL DEFAULT 0,
+				created_at TIMESTAMPTZ DEFAULT now()
+			);
+
+			CREATE INDEX IF NOT EXISTS fsm_queue_target_id_idx ON fsm_queue (target_id, seq);
+
+			COMMIT;
+		`,
+	},
+	{
+		Version: "0010",
+		Name:    "create_queue",
+		Type:    "down",
+		Data: `
+			DROP TABLE IF EXISTS fsm_queue;
+		`,
+	},
+	{
+		Version: "0011",
+		Name:    "create_dead_letters",
+		Type:    "up",
+		Data: `
+			BEGIN;
+
+			CREATE TABLE IF NOT EXISTS fsm_dead_letters (
+				id UUID PRIMARY KEY DEFAULT public.uuid_generate_v4(),
+				target_id VARCHAR NOT NULL,
+				event_id UUID NOT NULL,
+				state VARCHAR NOT NULL,
+				result_status VARCHAR NOT NULL DEFAULT '',
+				error TEXT NOT NULL DEFAULT '',
+				attempts INT NOT NULL DEFAULT 1,
+				payload JSONB,
+				created_at TIMESTAMPTZ DEFAULT now()
+			);
+
+			CREATE INDEX IF NOT EXISTS fsm_dead_letters_created_at_idx ON fsm_dead_letters (created_at, id);
+			CREATE INDEX IF NOT EXISTS fsm_dead_letters_target_id_idx ON fsm_dead_letters (target_id, created_at);
+
+			COMMIT;
+		`,
+	},
+	{
+		Version: "0011",
+		Name:    "create_dead_letters",
+		Type:    "down",
+		Data: `
+			DROP TABLE IF EXISTS fsm_dead_letters;
+		`,
+	},
+	{
+		Version: "0012",
+		Name:    "add_log_trace",
+		Type:    "up",
+		Data: `
+			BEGIN;
+
+			ALTER TABLE fsm_target_logs ADD COLUMN IF NOT EXISTS trace_id VARCHAR NOT NULL DEFAULT '';
+			ALTER TABLE fsm_target_logs ADD COLUMN IF NOT EXISTS span_id VARCHAR NOT NULL DEFAULT '';
+
+			COMMIT;
+		`,
+	},
+	{
+		Version: "0012",
+		Name:    "add_log_trace",
+		Type:    "down",
+		Data: `
+			BEGIN;
+
+			ALTER TABLE fsm_target_logs DROP COLUMN IF EXISTS trace_id;
+			ALTER TABLE fsm_target_logs DROP COLUMN IF EXISTS span_id;
+
+			COMMIT;
+		`,
+	},
+	{
+		Version: "0013",
+		Name:    "create_fences",
+		Type:    "up",
+		Data: `
+			CREATE TABLE IF NOT EXISTS fsm_fences (
+				target_id VARCHAR PRIMARY KEY,
+				token BIGINT NOT NULL,
+				updated_at TIMESTAMPTZ DEFAULT now()
+			);
+		`,
+	},
+	{
+		Version: "0013",
+		Name:    "create_fences",
+		Type:    "down",
+		Data: `
+			DROP TABLE IF EXISTS fsm_fences;
+		`,
+	},
+	{
+		Version: "0014",
+		Name:    "add_event_payload",
+		Type:    "up",
+		Data: `
+			ALTER TABLE fsm_target_events ADD COLUMN IF NOT EXISTS payload JSONB;
+		`,
+	},
+	{
+		Version: "0014",
+		Name:    "add_event_payload",
+		Type:    "down",
+		Data: `
+			ALTER TABLE fsm_target_events DROP COLUMN IF EXISTS payload;
+		`,
+	},
+	{
+		Version: "0015",
+		Name:    "add_dead_letter_resume_status",
+		Type:    "up",
+		Data: `
+			BEGIN;
+
+			ALTER TABLE fsm_dead_letters ALTER COLUMN event_id DROP NOT NULL;
+			ALTER TABLE fsm_dead_letters ADD COLUMN IF NOT EXISTS resume_status VARCHAR NOT NULL DEFAULT '';
+
+			COMMIT;
+		`,
+	},
+	{
+		Version: "0015",
+		Name:    "add_dead_letter_resume_status",
+		Type:    "down",
+		Data: `
+			BEGIN;
+
+			ALTER TABLE fsm_dead_letters DROP COLUMN IF EXISTS resume_status;
+			DELETE FROM fsm_dead_letters WHERE event_id IS NULL;
+			ALTER TABLE fsm_dead_letters ALTER COLUMN event_id SET NOT NULL;
+
 			COMMIT;
 		`,
 	},
@@ -0,0 +1,63 @@
+package event_fsm
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestMigrationSource(t *testing.T) {
+	src, err := newMigrationSource(migrations, Schema{TablePrefix: "test_"})
+	if err != nil {
+		t.Fatalf("newMigrationSource: %v", err)
+	}
+
+	version, err := src.First()
+	if err != nil {
+		t.Fatalf("First: %v", err)
+	}
+
+	for {
+		for _, read := range []func(uint) (io.ReadCloser, string, error){src.ReadUp, src.ReadDown} {
+			r, _, err := read(version)
+			if err != nil {
+				t.Fatalf("migration %d: %v", version, err)
+			}
+
+			if data, _ := io.ReadAll(r); len(data) == 0 {
+				t.Fatalf("migration %d is empty", version)
+			}
+		}
+
+		next, err := src.Next(version)
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+
+		if prev, _ := src.Prev(next); prev != version {
+			t.Fatalf("expected previous version of %d to be %d, got %d", next, version, prev)
+		}
+
+		version = next
+	}
+
+	if version != src.latest() {
+		t.Fatalf("expected the last version %d, got %d", src.latest(), version)
+	}
+}
+
+func TestSchemaQualify(t *testing.T) {
+	schema := Schema{Name: "fsm"}.forTenant("acme")
+	schema.TablePrefix = "billing_"
+
+	query := schema.qualify(`SELECT id FROM fsm_target_logs WHERE target_id = $1`)
+	if expected := `SELECT id FROM "fsm_acme".billing_fsm_target_logs WHERE target_id = $1`; query != expected {
+		t.Fatalf("expected %s, got %s", expected, query)
+	}
+
+	index := schema.prefix(`CREATE INDEX IF NOT EXISTS fsm_target_events_idx ON fsm_target_events (id);`)
+	if expected := `CREATE INDEX IF NOT EXISTS billing_fsm_target_events_idx ON billing_fsm_target_events (id);`; index != expected {
+		t.Fatalf("expected %s, got %s", expected, index)
+	}
+}
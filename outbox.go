@@ -0,0 +1,315 @@
+package event_fsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultRelayPollInterval = time.Second
+	defaultRelayBatchSize    = 100
+	defaultRelayLease        = time.Minute
+)
+
+// OutboxEntry is the notification that the target has entered the state.
+// It is written once per entering with the first log of the state, see Config.Outbox:
+// the retries and the next events executing the waiting state are not notified.
+type OutboxEntry struct {
+	ID string
+
+	// Seq orders the entries, the entries of one target are published in its order
+	Seq int64
+
+	TargetID string
+	EventID  string
+	LogID    string
+
+	// State is the state the target has entered
+	State StateName
+
+	// Status is the result status of the log, wait_next_event for the waiting state entered by the transition
+	// and empty otherwise
+	Status ResultStatus
+
+	// Attempts is the number of the deliveries of the entry
+	Attempts int
+
+	CreatedAt time.Time
+}
+
+type outboxEntryDto struct {
+	ID           string    `db:"id" json:"id"`
+	Seq          int64     `db:"seq" json:"seq"`
+	TargetID     string    `db:"target_id" json:"target_id"`
+	EventID      string    `db:"event_id" json:"event_id"`
+	LogID        string    `db:"log_id" json:"log_id"`
+	State        string    `db:"state" json:"state"`
+	ResultStatus string    `db:"result_status" json:"result_status,omitempty"`
+	Attempts     int       `db:"attempts" json:"attempts"`
+	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+}
+
+func (e *outboxEntryDto) toOutboxEntry() OutboxEntry {
+	return OutboxEntry{
+		ID:        e.ID,
+		Seq:       e.Seq,
+		TargetID:  e.TargetID,
+		EventID:   e.EventID,
+		LogID:     e.LogID,
+		State:     StateName(e.State),
+		Status:    ResultStatus(e.ResultStatus),
+		Attempts:  e.Attempts,
+		CreatedAt: e.CreatedAt,
+	}
+}
+
+func outboxEntryToDTO(e OutboxEntry) outboxEntryDto {
+	return outboxEntryDto{
+		ID:           e.ID,
+		Seq:          e.Seq,
+		TargetID:     e.TargetID,
+		EventID:      e.EventID,
+		LogID:        e.LogID,
+		State:        e.State.String(),
+		ResultStatus: e.Status.String(),
+		Attempts:     e.Attempts,
+		CreatedAt:    e.CreatedAt,
+	}
+}
+
+// OutboxStore is implemented by the stores which can keep the outbox entries
+type OutboxStore interface {
+	// SaveOutboxEntry saves the entry within the transaction of the context if there is one
+	SaveOutboxEntry(ctx context.Context, entry OutboxEntry) error
+
+	// ClaimOutboxEntries returns the entries of up to limit targets, ordered by Seq, increasing their attempts.
+	// The target is claimed only if its oldest entry is not claimed by another call, so the entries
+	// of one target are published by one relay at a time. The claimed entries are not returned
+	// by the other calls until the lease expires, so the entry which is not deleted is delivered again.
+	ClaimOutboxEntries(ctx context.Context, limit int, lease time.Duration) ([]OutboxEntry, error)
+
+	// DeleteOutboxEntries deletes the delivered entries, the missing entries are not an error
+	DeleteOutboxEntries(ctx context.Context, ids []string) error
+}
+
+// Publisher delivers the outbox entries to the downstream services,
+// see NewRedisStreamPublisher and NewWebhookPublisher
+type Publisher interface {
+	// Publish delivers the entry, the entry is delivered again if it returns an error.
+	// The same entry may be published more than once, the consumers deduplicate it by OutboxEntry.ID.
+	Publish(ctx context.Context, entry OutboxEntry) error
+}
+
+// saveEnteredLog saves the log of the state the target has entered,
+// with its outbox entry in one transaction if Config.Outbox is set
+func (f *FSM[T]) saveEnteredLog(ctx context.Context, log Log, save func(context.Context, Log) (string, error)) (string, error) {
+	if f.outbox == nil {
+		return save(ctx, log)
+	}
+
+	var id string
+	write := func(ctx context.Context) (err error) {
+		if id, err = save(ctx, log); err != nil {
+			return err
+		}
+
+		entry := OutboxEntry{
+			TargetID: log.TargetID,
+			EventID:  log.EventID,
+			LogID:    id,
+			State:    log.CurrentStateName,
+			Status:   log.CurrentResultStatus,
+		}
+
+		if err = f.outbox.SaveOutboxEntry(ctx, entry); err != nil {
+			return fmt.Errorf("f.outbox.SaveOutboxEntry: %w", err)
+		}
+
+		return nil
+	}
+
+	tx, ok := f.store.(TxStore)
+	if !ok {
+		return id, write(ctx)
+	}
+
+	return id, tx.RunInTx(ctx, write)
+}
+
+// saveLog saves the log of the current state, the first log of the state the target has entered
+// is saved with its outbox entry
+func (f *FSM[T]) saveLog(ctx context.Context, t *Target[T], log Log, save func(context.Context, Log) (string, error)) (string, error) {
+	if !t.entered {
+		return save(ctx, log)
+	}
+
+	id, err := f.saveEnteredLog(ctx, log, save)
+	if err == nil {
+		t.entered = false
+	}
+
+	return id, err
+}
+
+type OutboxRelayConfig[T comparable] struct {
+	// FSM writes the outbox entries, it must be created with Config.Outbox
+	FSM *FSM[T]
+
+	// Publisher delivers the entries, required
+	Publisher Publisher
+
+	// Logger is the logger of the FSM by default
+	Logger *zap.Logger
+
+	// PollInterval is the interval of polling the entries, 1 second by default
+	PollInterval time.Duration
+
+	// BatchSize is the maximum number of the targets claimed by one poll, 100 by default
+	BatchSize int
+
+	// Lease is the time the claimed entries are hidden from the other replicas, 1 minute by default.
+	// The entries which failed to publish are delivered again when it expires.
+	Lease time.Duration
+}
+
+// OutboxRelay delivers the outbox entries to the Publisher.
+// Any number of replicas can run the relay over the same store.
+// The entry is delivered at least once: it is deleted only after it was published.
+// The entries of one target are delivered in order, the failed entry holds back the next ones
+// until it is published.
+type OutboxRelay[T comparable] struct {
+	l *zap.Logger
+
+	store     OutboxStore
+	publisher Publisher
+
+	pollInterval time.Duration
+	batchSize    int
+	lease        time.Duration
+}
+
+func NewOutboxRelay[T comparable](cfg OutboxRelayConfig[T]) (*OutboxRelay[T], error) {
+	if cfg.FSM == nil {
+		return nil, errors.New("FSM is required")
+	}
+
+	if cfg.Publisher == nil {
+		return nil, errors.New("Publisher is required")
+	}
+
+	if cfg.FSM.outbox == nil {
+		return nil, errors.New("FSM is created without Config.Outbox")
+	}
+
+	r := &OutboxRelay[T]{
+		l:         cfg.Logger,
+		store:     cfg.FSM.outbox,
+		publisher: cfg.Publisher,
+
+		pollInterval: cfg.PollInterval,
+		batchSize:    cfg.BatchSize,
+		lease:        cfg.Lease,
+	}
+
+	if r.l == nil {
+		r.l = cfg.FSM.l
+	}
+
+	if r.pollInterval <= 0 {
+		r.pollInterval = defaultRelayPollInterval
+	}
+
+	if r.batchSize <= 0 {
+		r.batchSize = defaultRelayBatchSize
+	}
+
+	if r.lease <= 0 {
+		r.lease = defaultRelayLease
+	}
+
+	return r, nil
+}
+
+// Run polls and delivers the entries until ctx is done
+func (r *OutboxRelay[T]) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		// the full batch means there may be more entries
+		for {
+			n, err := r.Poll(ctx)
+			if err != nil {
+				r.l.Error("outbox relay poll", zap.Error(err))
+			}
+
+			if err != nil || n < r.batchSize || ctx.Err() != nil {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll claims the entries of one batch of the targets and delivers them,
+// it returns the number of the claimed targets
+func (r *OutboxRelay[T]) Poll(ctx context.Context) (int, error) {
+	entries, err := r.store.ClaimOutboxEntries(ctx, r.batchSize, r.lease)
+	if err != nil {
+		return 0, fmt.Errorf("r.store.ClaimOutboxEntries: %w", err)
+	}
+
+	var (
+		targets = make(map[string][]OutboxEntry)
+		order   []string
+	)
+
+	for _, e := range entries {
+		if _, ok := targets[e.TargetID]; !ok {
+			order = append(order, e.TargetID)
+		}
+
+		targets[e.TargetID] = append(targets[e.TargetID], e)
+	}
+
+	for _, targetID := range order {
+		r.deliver(ctx, targets[targetID])
+	}
+
+	return len(order), nil
+}
+
+// deliver publishes the entries of one target ordered by Seq, it stops at the first failure
+func (r *OutboxRelay[T]) deliver(ctx context.Context, entries []OutboxEntry) {
+	published := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if err := r.publisher.Publish(ctx, e); err != nil {
+			r.l.Error(
+				"error publishing outbox entry", zap.Error(err),
+				zap.String("id", e.ID), zap.String("target_id", e.TargetID), zap.Int("attempt", e.Attempts),
+			)
+
+			// the rest of the entries are delivered again after the lease, in order
+			break
+		}
+
+		published = append(published, e.ID)
+	}
+
+	if len(published) == 0 {
+		return
+	}
+
+	if err := r.store.DeleteOutboxEntries(ctx, published); err != nil {
+		r.l.Error("r.store.DeleteOutboxEntries", zap.Error(err), zap.Strings("ids", published))
+	}
+}
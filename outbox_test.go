@@ -0,0 +1,87 @@
+package event_fsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// publisherRecorder records the published entries, it fails while fail is positive
+type publisherRecorder struct {
+	fail    int
+	entries []OutboxEntry
+}
+
+func (p *publisherRecorder) Publish(_ context.Context, entry OutboxEntry) error {
+	if p.fail > 0 {
+		p.fail--
+		return errors.New("publisher is down")
+	}
+
+	p.entries = append(p.entries, entry)
+
+	return nil
+}
+
+func TestOutbox(t *testing.T) {
+	ctx := context.Background()
+
+	sd := NewStateDetector[*eventData]()
+	first := sd.NewState(StateFirstCheck, &stateFlaky{}, StateTypeTransition)
+	wait := sd.NewState(StateManualAdd, &stateFlaky{}, StateTypeWaitEvent)
+	first.SetNext(wait, ResultStatusOk)
+	wait.SetTerminal()
+	sd.SetMainState(StateFirstCheck)
+
+	fsm, err := NewFSM(&Config[*eventData]{Logger: zap.NewNop(), StateDetector: sd, Store: NewMemoryStore(), Outbox: true})
+	if err != nil {
+		t.Fatalf("NewFSM: %v", err)
+	}
+
+	ed := newEventData(1)
+	if _, err = fsm.ProcessEvent(ctx, NewTarget(&ed)); err != nil {
+		t.Fatalf("ProcessEvent: %v", err)
+	}
+
+	publisher := &publisherRecorder{fail: 1}
+	relay, err := NewOutboxRelay(OutboxRelayConfig[*eventData]{FSM: fsm, Publisher: publisher, Lease: time.Nanosecond})
+	if err != nil {
+		t.Fatalf("NewOutboxRelay: %v", err)
+	}
+
+	// the failed entry holds back the next one
+	if n, err := relay.Poll(ctx); err != nil || n != 1 || len(publisher.entries) != 0 {
+		t.Fatalf("expected 1 target and nothing published, got %d, %v, %d entries", n, err, len(publisher.entries))
+	}
+
+	time.Sleep(time.Millisecond)
+
+	if n, err := relay.Poll(ctx); err != nil || n != 1 {
+		t.Fatalf("expected 1 target, got %d, %v", n, err)
+	}
+
+	if len(publisher.entries) != 2 ||
+		publisher.entries[0].State != StateFirstCheck || publisher.entries[1].State != StateManualAdd {
+		t.Fatalf("expected the entries of %s and %s in order, got %+v", StateFirstCheck, StateManualAdd, publisher.entries)
+	}
+
+	if e := publisher.entries[0]; e.TargetID != ed.ID() || e.LogID == "" || e.Attempts != 2 {
+		t.Fatalf("unexpected entry %+v", e)
+	}
+
+	if n, err := relay.Poll(ctx); err != nil || n != 0 {
+		t.Fatalf("expected the published entries to be deleted, got %d, %v", n, err)
+	}
+
+	// the next event executes the waiting state the target has already entered
+	if _, err = fsm.ProcessEvent(ctx, NewTarget(&ed)); err != nil {
+		t.Fatalf("ProcessEvent: %v", err)
+	}
+
+	if n, err := relay.Poll(ctx); err != nil || n != 0 || len(publisher.entries) != 2 {
+		t.Fatalf("expected no entry of the entered state, got %d, %v, %+v", n, err, publisher.entries)
+	}
+}
@@ -0,0 +1,77 @@
+package event_fsm
+
+import (
+	"context"
+	"sync"
+)
+
+// pendingEvents keeps the events enqueued with LockModeEnqueue while their targets are busy,
+// the queues are keyed by the lock keys of the targets, see FSM.lockKey
+type pendingEvents[T comparable] struct {
+	mu      sync.Mutex
+	targets map[string][]pendingEvent[T]
+}
+
+type pendingEvent[T comparable] struct {
+	ctx context.Context
+	t   Target[T]
+}
+
+func newPendingEvents[T comparable]() *pendingEvents[T] {
+	return &pendingEvents[T]{
+		targets: make(map[string][]pendingEvent[T]),
+	}
+}
+
+// appendIfPending adds the event to the queue of its target only if the queue exists,
+// so the event does not overtake the events enqueued before it
+func (p *pendingEvents[T]) appendIfPending(ctx context.Context, key string, t Target[T]) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	queue, ok := p.targets[key]
+	if !ok {
+		return false
+	}
+
+	p.targets[key] = append(queue, pendingEvent[T]{ctx: ctx, t: t})
+
+	return true
+}
+
+// push adds the event to the queue of its target.
+// It returns true if the queue was created, then the caller must start draining it.
+func (p *pendingEvents[T]) push(ctx context.Context, key string, t Target[T]) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	queue, ok := p.targets[key]
+	p.targets[key] = append(queue, pendingEvent[T]{ctx: ctx, t: t})
+
+	return !ok
+}
+
+// first returns the oldest event of the target, it stays in the queue until removeFirst
+func (p *pendingEvents[T]) first(key string) pendingEvent[T] {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.targets[key][0]
+}
+
+// removeFirst removes the oldest event of the target.
+// It returns false and removes the queue if there are no more events.
+func (p *pendingEvents[T]) removeFirst(key string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	queue := p.targets[key][1:]
+	if len(queue) == 0 {
+		delete(p.targets, key)
+		return false
+	}
+
+	p.targets[key] = queue
+
+	return true
+}
@@ -0,0 +1,391 @@
+package event_fsm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultWorkerConcurrency       = 4
+	defaultWorkerPollInterval      = time.Second
+	defaultWorkerBatchSize         = 100
+	defaultWorkerVisibilityTimeout = time.Minute
+	defaultWorkerMaxAttempts       = 3
+
+	// queueIdempotencyKeyPrefix prefixes the IDs of the queued events used as the idempotency keys
+	queueIdempotencyKeyPrefix = "queue:"
+)
+
+// QueuedEvent is the event enqueued to be processed by the Worker, see FSM.Enqueue
+type QueuedEvent struct {
+	ID       string
+	TargetID string
+
+	Payload EventPayload
+
+	// IdempotencyKey is the key of the event set with Target.SetIdempotencyKey, optional
+	IdempotencyKey string
+
+	// Attempts is the number of the deliveries of the event
+	Attempts int
+
+	CreatedAt time.Time
+
+	// payloadErr is the error of reading the stored payload, the event fails to process with it
+	payloadErr error
+}
+
+type queuedEventDto struct {
+	ID             string          `db:"id" json:"id"`
+	Seq            int64           `db:"seq" json:"-"`
+	TargetID       string          `db:"target_id" json:"target_id"`
+	Payload        json.RawMessage `db:"payload" json:"payload,omitempty"`
+	IdempotencyKey string          `db:"idempotency_key" json:"idempotency_key,omitempty"`
+	Attempts       int             `db:"attempts" json:"attempts"`
+	CreatedAt      time.Time       `db:"created_at" json:"created_at"`
+}
+
+func (e *queuedEventDto) toQueuedEvent() QueuedEvent {
+	event := QueuedEvent{
+		ID:             e.ID,
+		TargetID:       e.TargetID,
+		IdempotencyKey: e.IdempotencyKey,
+		Attempts:       e.Attempts,
+		CreatedAt:      e.CreatedAt,
+	}
+
+	if len(e.Payload) > 0 {
+		if err := json.Unmarshal(e.Payload, &event.Payload); err != nil {
+			event.payloadErr = fmt.Errorf("json.Unmarshal of payload of queued event %s: %w", e.ID, err)
+		}
+	}
+
+	return event
+}
+
+func queuedEventToDTO(e QueuedEvent) (queuedEventDto, error) {
+	dto := queuedEventDto{
+		ID:             e.ID,
+		TargetID:       e.TargetID,
+		IdempotencyKey: e.IdempotencyKey,
+		Attempts:       e.Attempts,
+		CreatedAt:      e.CreatedAt,
+	}
+
+	if !e.Payload.IsEmpty() {
+		payload, err := json.Marshal(e.Payload)
+		if err != nil {
+			return queuedEventDto{}, fmt.Errorf("json.Marshal of payload: %w", err)
+		}
+
+		dto.Payload = payload
+	}
+
+	return dto, nil
+}
+
+// QueueStore is implemented by the durable queues of the events, see Config.Queue and NewRedisStreamQueue
+type QueueStore interface {
+	// EnqueueEvent saves the event to the end of the queue and returns its ID
+	EnqueueEvent(ctx context.Context, event QueuedEvent) (string, error)
+
+	// ClaimQueuedEvents returns the visible events, oldest first, increasing the attempts of the events
+	// which are delivered first: the next events of the target are counted when they become its oldest one.
+	// The claimed events are hidden from the other calls until the visibility timeout expires,
+	// so the event which is not deleted is delivered again.
+	// limit is the maximum number of the targets or of the events, depending on the queue.
+	ClaimQueuedEvents(ctx context.Context, limit int, visibility time.Duration) ([]QueuedEvent, error)
+
+	// DeleteQueuedEvent deletes the processed event, the missing event is not an error
+	DeleteQueuedEvent(ctx context.Context, id string) error
+}
+
+// Enqueue saves the event of the target to the queue and returns its ID without processing it,
+// the event is processed by the Worker. The idempotency key of the target is kept with the event.
+func (f *FSM[T]) Enqueue(ctx context.Context, t Target[T], payload EventPayload) (string, error) {
+	if f.queue == nil {
+		return "", fmt.Errorf("queue: %w", ErrNotSupported)
+	}
+
+	if t.data.IsNull() {
+		return "", fmt.Errorf("target is nil")
+	}
+
+	id, err := f.queue.EnqueueEvent(ctx, QueuedEvent{
+		TargetID:       t.ID(),
+		Payload:        payload,
+		IdempotencyKey: t.idempotencyKey,
+	})
+	if err != nil {
+		return "", fmt.Errorf("f.queue.EnqueueEvent: %w", err)
+	}
+
+	return id, nil
+}
+
+type WorkerConfig[T comparable] struct {
+	// FSM processes the queued events, it must have the queue, see Config.Queue
+	FSM *FSM[T]
+
+	// Load loads the target of the queued event
+	Load TargetLoader[T]
+
+	// Logger is the logger of the FSM by default
+	Logger *zap.Logger
+
+	// Concurrency is the number of the targets processed at a time, 4 by default
+	Concurrency int
+
+	// PollInterval is the interval of polling the queue when it is empty, 1 second by default
+	PollInterval time.Duration
+
+	// BatchSize is the limit of one claim of the queue, 100 by default, see QueueStore.ClaimQueuedEvents
+	BatchSize int
+
+	// VisibilityTimeout is the time the claimed events are hidden from the other replicas, 1 minute by default.
+	// It must be longer than the processing of the whole batch.
+	VisibilityTimeout time.Duration
+
+	// MaxAttempts is the number of the deliveries of the event before it is moved to the dead letters
+	// if Config.DeadLetters is set, 3 by default. Without the dead letters the event which failed to process
+	// is kept and holds back the next events of the target. The failure of the state recorded by the FSM
+	// is not redelivered.
+	MaxAttempts int
+}
+
+// Worker processes the events of the queue, see FSM.Enqueue.
+// Any number of replicas can run the worker over the same queue.
+// The events of one target are processed one at a time in the order they were enqueued,
+// the event which failed to process holds back the next ones until it is processed or moved to the dead letters.
+// The redelivered event is deduplicated by the idempotency key if the Store implements IdempotencyStore:
+// the ID of the queued event is used as the key if the event has none.
+type Worker[T comparable] struct {
+	l *zap.Logger
+
+	fsm   *FSM[T]
+	queue QueueStore
+	load  TargetLoader[T]
+
+	concurrency       int
+	pollInterval      time.Duration
+	batchSize         int
+	visibilityTimeout time.Duration
+	maxAttempts       int
+}
+
+func NewWorker[T comparable](cfg WorkerConfig[T]) (*Worker[T], error) {
+	if cfg.FSM == nil {
+		return nil, errors.New("FSM is required")
+	}
+
+	if cfg.Load == nil {
+		return nil, errors.New("Load is required")
+	}
+
+	if cfg.FSM.queue == nil {
+		return nil, fmt.Errorf("queue: %w", ErrNotSupported)
+	}
+
+	w := &Worker[T]{
+		l:     cfg.Logger,
+		fsm:   cfg.FSM,
+		queue: cfg.FSM.queue,
+		load:  cfg.Load,
+
+		concurrency:       cfg.Concurrency,
+		pollInterval:      cfg.PollInterval,
+		batchSize:         cfg.BatchSize,
+		visibilityTimeout: cfg.VisibilityTimeout,
+		maxAttempts:       cfg.MaxAttempts,
+	}
+
+	if w.l == nil {
+		w.l = cfg.FSM.l
+	}
+
+	if w.concurrency <= 0 {
+		w.concurrency = defaultWorkerConcurrency
+	}
+
+	if w.pollInterval <= 0 {
+		w.pollInterval = defaultWorkerPollInterval
+	}
+
+	if w.batchSize <= 0 {
+		w.batchSize = defaultWorkerBatchSize
+	}
+
+	if w.visibilityTimeout <= 0 {
+		w.visibilityTimeout = defaultWorkerVisibilityTimeout
+	}
+
+	if w.maxAttempts <= 0 {
+		w.maxAttempts = defaultWorkerMaxAttempts
+	}
+
+	return w, nil
+}
+
+// Run processes the queued events until ctx is done.
+// On shutdown it stops claiming the events and returns when the events being processed are finished,
+// the claimed events which are not started are delivered again after the visibility timeout.
+func (w *Worker[T]) Run(ctx context.Context) error {
+	ticker := time.NewTicker(w.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		// the full batch means there may be more events
+		for ctx.Err() == nil {
+			n, err := w.Poll(ctx)
+			if err != nil {
+				w.l.Error("worker poll", zap.Error(err))
+			}
+
+			if err != nil || n < w.batchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll claims one batch of the queued events and processes them, it returns the number of the claimed events.
+// The targets are processed concurrently, the events of one target one by one.
+// Poll returns when the batch is processed; after ctx is done the events which are not started are skipped.
+func (w *Worker[T]) Poll(ctx context.Context) (int, error) {
+	events, err := w.queue.ClaimQueuedEvents(ctx, w.batchSize, w.visibilityTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("w.queue.ClaimQueuedEvents: %w", err)
+	}
+
+	var (
+		targets = make(map[string][]QueuedEvent)
+		order   []string
+	)
+
+	for _, e := range events {
+		if _, ok := targets[e.TargetID]; !ok {
+			order = append(order, e.TargetID)
+		}
+
+		targets[e.TargetID] = append(targets[e.TargetID], e)
+	}
+
+	var (
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, w.concurrency)
+	)
+
+	for _, targetID := range order {
+		select {
+		case <-ctx.Done():
+		case sem <- struct{}{}:
+			wg.Add(1)
+			go func(events []QueuedEvent) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+
+				w.processTarget(ctx, events)
+			}(targets[targetID])
+		}
+	}
+
+	wg.Wait()
+
+	return len(events), nil
+}
+
+// processTarget processes the events of one target in order, it stops at the event to be delivered again
+func (w *Worker[T]) processTarget(ctx context.Context, events []QueuedEvent) {
+	for _, e := range events {
+		if ctx.Err() != nil {
+			return
+		}
+
+		// the started event is finished on shutdown
+		err := w.process(context.WithoutCancel(ctx), e)
+		if err != nil {
+			w.l.Error(
+				"error processing queued event", zap.Error(err),
+				zap.String("id", e.ID), zap.String("target_id", e.TargetID), zap.Int("attempt", e.Attempts),
+			)
+
+			if e.Attempts < w.maxAttempts || !w.saveDeadLetter(ctx, e, err) {
+				// the rest of the events are delivered again after the visibility timeout, in order
+				return
+			}
+		}
+
+		if err = w.queue.DeleteQueuedEvent(context.WithoutCancel(ctx), e.ID); err != nil {
+			w.l.Error("w.queue.DeleteQueuedEvent", zap.Error(err), zap.String("id", e.ID))
+			return
+		}
+	}
+}
+
+// saveDeadLetter moves the event which failed to process to the dead letters,
+// it returns false if the event is kept: the dead letters are not recorded or failed to save
+func (w *Worker[T]) saveDeadLetter(ctx context.Context, e QueuedEvent, err error) bool {
+	if w.fsm.deadLetters == nil {
+		return false
+	}
+
+	dl := DeadLetter{
+		TargetID: e.TargetID,
+		Error:    err.Error(),
+		Attempts: e.Attempts,
+		Payload:  e.Payload,
+	}
+
+	if _, err = w.fsm.deadLetters.SaveDeadLetter(context.WithoutCancel(ctx), dl); err != nil {
+		w.l.Error("w.fsm.deadLetters.SaveDeadLetter", zap.Error(err), zap.String("id", e.ID))
+		return false
+	}
+
+	return true
+}
+
+// process processes the event, the failure of the state recorded by the FSM is not redelivered
+func (w *Worker[T]) process(ctx context.Context, e QueuedEvent) error {
+	if e.payloadErr != nil {
+		return e.payloadErr
+	}
+
+	data, err := w.load(ctx, e.TargetID)
+	if err != nil {
+		return fmt.Errorf("w.load: %w", err)
+	}
+
+	t := NewTarget(data)
+	t.payload = e.Payload
+
+	switch {
+	case e.IdempotencyKey != "":
+		t.SetIdempotencyKey(e.IdempotencyKey)
+	case w.fsm.supportsIdempotency():
+		t.SetIdempotencyKey(queueIdempotencyKeyPrefix + e.ID)
+	}
+
+	// the worker waits for the target regardless of Config.LockMode to keep the order of the events
+	_, err = w.fsm.lockAndProcess(ctx, t, true)
+
+	var execErr *ExecutionError
+	if err != nil && !errors.As(err, &execErr) && !errors.Is(err, ErrStateFailed) && !errors.Is(err, ErrNoNextState) {
+		return err
+	}
+
+	return nil
+}
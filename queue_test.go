@@ -0,0 +1,193 @@
+package event_fsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestWorker(t *testing.T) {
+	ctx := context.Background()
+
+	sd := NewStateDetector[*eventData]()
+	first := sd.NewState(StateFirstCheck, &stateFlaky{}, StateTypeTransition)
+	wait := sd.NewState(StateManualAdd, &stateFlaky{}, StateTypeWaitEvent)
+	printResult := sd.NewState(StatePrintResult, &stateFlaky{}, StateTypeTransition)
+	printResult.SetTerminal()
+	first.SetNext(wait, ResultStatusOk)
+	wait.SetNext(printResult, ResultStatusOk)
+	sd.SetMainState(StateFirstCheck)
+
+	store := NewMemoryStore()
+	fsm, err := NewFSM(&Config[*eventData]{Logger: zap.NewNop(), StateDetector: sd, Store: store})
+	if err != nil {
+		t.Fatalf("NewFSM: %v", err)
+	}
+
+	ed := newEventData(1)
+	for _, name := range []string{"first", "second"} {
+		if _, err = fsm.Enqueue(ctx, NewTarget(&ed), EventPayload{Name: name}); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+	}
+
+	if ed.GetState() != "" {
+		t.Fatalf("expected the enqueued events not processed, got state %s", ed.GetState())
+	}
+
+	loads := 0
+	worker, err := NewWorker(WorkerConfig[*eventData]{
+		FSM: fsm,
+		Load: func(ctx context.Context, targetID string) (TargetData[*eventData], error) {
+			if loads++; loads == 1 {
+				return nil, errors.New("database is down")
+			}
+
+			return &ed, nil
+		},
+		VisibilityTimeout: time.Nanosecond,
+	})
+	if err != nil {
+		t.Fatalf("NewWorker: %v", err)
+	}
+
+	// the failed event holds back the next one
+	if n, err := worker.Poll(ctx); err != nil || n != 2 || ed.GetState() != "" {
+		t.Fatalf("expected 2 claimed events and nothing processed, got %d, %v, state %s", n, err, ed.GetState())
+	}
+
+	time.Sleep(time.Millisecond)
+
+	// only the delivered event is counted
+	claimed, err := store.ClaimQueuedEvents(ctx, 10, time.Nanosecond)
+	if err != nil || len(claimed) != 2 || claimed[0].Attempts != 2 || claimed[1].Attempts != 0 {
+		t.Fatalf("expected the attempts 2 and 0, got %+v, %v", claimed, err)
+	}
+
+	time.Sleep(time.Millisecond)
+
+	if n, err := worker.Poll(ctx); err != nil || n != 2 {
+		t.Fatalf("expected 2 claimed events, got %d, %v", n, err)
+	}
+
+	if ed.GetState() != StatePrintResult {
+		t.Fatalf("expected state %s, got %s", StatePrintResult, ed.GetState())
+	}
+
+	page, err := fsm.Events(ctx, ed.ID(), EventsFilter{})
+	if err != nil || len(page.Events) != 2 {
+		t.Fatalf("expected 2 events, got %+v, %v", page.Events, err)
+	}
+
+	// the events are listed newest first
+	if page.Events[0].Payload.Name != "second" || page.Events[1].Payload.Name != "first" {
+		t.Fatalf("expected the events processed in order, got %+v", page.Events)
+	}
+
+	if n, err := worker.Poll(ctx); err != nil || n != 0 {
+		t.Fatalf("expected the processed events to be deleted, got %d, %v", n, err)
+	}
+}
+
+func TestWorkerMaxAttempts(t *testing.T) {
+	ctx := context.Background()
+
+	sd := NewStateDetector[*eventData]()
+	first := sd.NewState(StateFirstCheck, &stateFlaky{}, StateTypeTransition)
+	first.SetTerminal()
+	sd.SetMainState(StateFirstCheck)
+
+	for _, deadLetters := range []bool{false, true} {
+		t.Run(fmt.Sprintf("dead letters %t", deadLetters), func(t *testing.T) {
+			fsm, err := NewFSM(&Config[*eventData]{
+				Logger: zap.NewNop(), StateDetector: sd, Store: NewMemoryStore(), DeadLetters: deadLetters,
+			})
+			if err != nil {
+				t.Fatalf("NewFSM: %v", err)
+			}
+
+			ed := newEventData(1)
+			if _, err = fsm.Enqueue(ctx, NewTarget(&ed), EventPayload{Name: "first"}); err != nil {
+				t.Fatalf("Enqueue: %v", err)
+			}
+
+			worker, err := NewWorker(WorkerConfig[*eventData]{
+				FSM: fsm,
+				Load: func(ctx context.Context, targetID string) (TargetData[*eventData], error) {
+					return nil, errors.New("database is down")
+				},
+				VisibilityTimeout: time.Nanosecond,
+				MaxAttempts:       1,
+			})
+			if err != nil {
+				t.Fatalf("NewWorker: %v", err)
+			}
+
+			if n, err := worker.Poll(ctx); err != nil || n != 1 {
+				t.Fatalf("expected 1 claimed event, got %d, %v", n, err)
+			}
+
+			time.Sleep(time.Millisecond)
+
+			n, err := worker.Poll(ctx)
+			if err != nil {
+				t.Fatalf("Poll: %v", err)
+			}
+
+			if !deadLetters {
+				if n != 1 {
+					t.Fatalf("expected the failed event to be kept, got %d", n)
+				}
+
+				return
+			}
+
+			if n != 0 {
+				t.Fatalf("expected the failed event to be moved to the dead letters, got %d", n)
+			}
+
+			page, err := fsm.DeadLetters(ctx, DeadLetterFilter{TargetID: ed.ID()})
+			if err != nil || len(page.DeadLetters) != 1 || page.DeadLetters[0].Payload.Name != "first" {
+				t.Fatalf("expected the dead letter of the event, got %+v, %v", page.DeadLetters, err)
+			}
+		})
+	}
+}
+
+func TestWorkerCorruptPayload(t *testing.T) {
+	ctx := context.Background()
+
+	sd := NewStateDetector[*eventData]()
+	first := sd.NewState(StateFirstCheck, &stateFlaky{}, StateTypeTransition)
+	first.SetTerminal()
+	sd.SetMainState(StateFirstCheck)
+
+	fsm, err := NewFSM(&Config[*eventData]{Logger: zap.NewNop(), StateDetector: sd, Store: NewMemoryStore()})
+	if err != nil {
+		t.Fatalf("NewFSM: %v", err)
+	}
+
+	ed := newEventData(1)
+	worker, err := NewWorker(WorkerConfig[*eventData]{
+		FSM: fsm,
+		Load: func(ctx context.Context, targetID string) (TargetData[*eventData], error) {
+			return &ed, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewWorker: %v", err)
+	}
+
+	dto := queuedEventDto{ID: "1", TargetID: ed.ID(), Payload: []byte(`{"name":`)}
+	if err = worker.process(ctx, dto.toQueuedEvent()); err == nil {
+		t.Fatal("expected the error of the corrupt payload")
+	}
+
+	if ed.GetState() != "" {
+		t.Fatalf("expected the event not processed, got state %s", ed.GetState())
+	}
+}
@@ -0,0 +1,233 @@
+package event_fsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultRecoveryThreshold = 10 * time.Minute
+	defaultRecoveryInterval  = time.Minute
+	defaultRecoveryBatchSize = 100
+
+	abandonedLogError = "abandoned: the state was not finished in time"
+)
+
+// AbandonedRun is the execution of the state which was started and never finished,
+// e.g. the process died while the executor was running
+type AbandonedRun struct {
+	// Log is the log of the unfinished execution, it is marked with ResultStatusAbandoned
+	Log Log
+
+	// Event is the event the execution belongs to
+	Event Event
+
+	// Resumed is true if the state was run again
+	Resumed bool
+
+	// Err is the error of the resumed run or of the lookup of its event
+	Err error
+}
+
+// RecoveryStore is implemented by the stores which can find the abandoned executions
+type RecoveryStore interface {
+	// ClaimAbandonedLogs marks up to limit logs without result status, older than the given duration,
+	// with ResultStatusAbandoned and returns them, oldest first.
+	// Every log is returned by one call only.
+	ClaimAbandonedLogs(ctx context.Context, olderThan time.Duration, limit int) ([]Log, error)
+}
+
+type RecoveryConfig[T comparable] struct {
+	// FSM resumes the abandoned executions, its Store must implement RecoveryStore
+	FSM *FSM[T]
+
+	// Load loads the target of the abandoned execution, required if Resume is set
+	Load TargetLoader[T]
+
+	// Logger is the logger of the FSM by default
+	Logger *zap.Logger
+
+	// Threshold is the age of the unfinished execution to treat it as abandoned, 10 minutes by default.
+	// It must be longer than the longest execution of the states, including their retries.
+	Threshold time.Duration
+
+	// Interval is the interval of the sweeps, 1 minute by default
+	Interval time.Duration
+
+	// BatchSize is the maximum number of the executions handled by one sweep, 100 by default
+	BatchSize int
+
+	// Resume runs the abandoned states again within their events
+	Resume bool
+
+	// OnAbandoned is called for every abandoned execution after it is handled, optional
+	OnAbandoned func(ctx context.Context, run AbandonedRun)
+}
+
+// Recovery finds the executions of the states abandoned mid-run, reports them
+// and optionally resumes the targets from the state recorded in the log.
+//
+// The resumed state runs its executor again, so the executor must be idempotent:
+// it may be called more than once for the same target, state and event
+// (see LockToken and EventPayloadFromContext to deduplicate the side effects).
+// The state is not resumed if the target has left it.
+type Recovery[T comparable] struct {
+	l *zap.Logger
+
+	fsm   *FSM[T]
+	store RecoveryStore
+	load  TargetLoader[T]
+
+	threshold   time.Duration
+	interval    time.Duration
+	batchSize   int
+	resume      bool
+	onAbandoned func(ctx context.Context, run AbandonedRun)
+}
+
+func NewRecovery[T comparable](cfg RecoveryConfig[T]) (*Recovery[T], error) {
+	if cfg.FSM == nil {
+		return nil, errors.New("FSM is required")
+	}
+
+	if cfg.Resume && cfg.Load == nil {
+		return nil, errors.New("Load is required to resume")
+	}
+
+	store, ok := cfg.FSM.store.(RecoveryStore)
+	if !ok {
+		return nil, fmt.Errorf("recovery: %w", ErrNotSupported)
+	}
+
+	r := &Recovery[T]{
+		l:     cfg.Logger,
+		fsm:   cfg.FSM,
+		store: store,
+		load:  cfg.Load,
+
+		threshold:   cfg.Threshold,
+		interval:    cfg.Interval,
+		batchSize:   cfg.BatchSize,
+		resume:      cfg.Resume,
+		onAbandoned: cfg.OnAbandoned,
+	}
+
+	if r.l == nil {
+		r.l = cfg.FSM.l
+	}
+
+	if r.threshold <= 0 {
+		r.threshold = defaultRecoveryThreshold
+	}
+
+	if r.interval <= 0 {
+		r.interval = defaultRecoveryInterval
+	}
+
+	if r.batchSize <= 0 {
+		r.batchSize = defaultRecoveryBatchSize
+	}
+
+	return r, nil
+}
+
+// Run sweeps the abandoned executions until ctx is done
+func (r *Recovery[T]) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			runs, err := r.Sweep(ctx)
+			if err != nil {
+				r.l.Error("recovery sweep", zap.Error(err))
+			}
+
+			if err != nil || len(runs) < r.batchSize || ctx.Err() != nil {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep handles one batch of the abandoned executions and returns them
+func (r *Recovery[T]) Sweep(ctx context.Context) ([]AbandonedRun, error) {
+	logs, err := r.store.ClaimAbandonedLogs(ctx, r.threshold, r.batchSize)
+	if err != nil {
+		return nil, fmt.Errorf("r.store.ClaimAbandonedLogs: %w", err)
+	}
+
+	runs := make([]AbandonedRun, 0, len(logs))
+	for _, log := range logs {
+		run := r.recover(ctx, log)
+
+		fields := []zap.Field{
+			zap.String("target_id", log.TargetID),
+			zap.String("event_id", log.EventID),
+			zap.String("state", log.CurrentStateName.String()),
+			zap.Bool("resumed", run.Resumed),
+		}
+		if run.Err != nil {
+			fields = append(fields, zap.Error(run.Err))
+		}
+		r.l.Warn("abandoned state execution", fields...)
+
+		if r.onAbandoned != nil {
+			r.onAbandoned(ctx, run)
+		}
+
+		runs = append(runs, run)
+	}
+
+	return runs, nil
+}
+
+func (r *Recovery[T]) recover(ctx context.Context, log Log) AbandonedRun {
+	run := AbandonedRun{Log: log}
+
+	run.Event, run.Err = r.fsm.store.GetEvent(ctx, log.EventID)
+	if run.Err != nil || !r.resume {
+		return run
+	}
+
+	data, err := r.load(ctx, log.TargetID)
+	if err != nil {
+		run.Err = fmt.Errorf("r.load: %w", err)
+		return run
+	}
+
+	state, err := r.fsm.stateOf(ctx, data)
+	if err != nil {
+		run.Err = err
+		return run
+	}
+
+	if state == "" {
+		// the new target starts from the main state
+		state, _ = r.fsm.stateDetector.getMainState()
+	}
+
+	if state != log.CurrentStateName {
+		// the target has left the state, e.g. the state was resumed by another event
+		return run
+	}
+
+	t := NewTarget(data)
+	t.payload = run.Event.Payload
+	t.resumeEventID = log.EventID
+
+	_, run.Err = r.fsm.lockAndProcess(ctx, t, true)
+	run.Resumed = true
+
+	return run
+}
@@ -0,0 +1,72 @@
+package event_fsm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestRecovery(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+
+	sd := NewStateDetector[*eventData]()
+	first := sd.NewState(StateFirstCheck, &stateFlaky{}, StateTypeTransition)
+	wait := sd.NewState(StateManualAdd, &stateFlaky{}, StateTypeWaitEvent)
+	printResult := sd.NewState(StatePrintResult, &stateFlaky{}, StateTypeTransition)
+	printResult.SetTerminal()
+	first.SetNext(wait, ResultStatusOk)
+	wait.SetNext(printResult, ResultStatusOk)
+	sd.SetMainState(StateFirstCheck)
+
+	fsm, err := NewFSM(&Config[*eventData]{Logger: zap.NewNop(), StateDetector: sd, Store: store})
+	if err != nil {
+		t.Fatalf("NewFSM: %v", err)
+	}
+
+	ed := newEventData(1)
+	if _, err = fsm.ProcessEvent(ctx, NewTarget(&ed)); err != nil {
+		t.Fatalf("ProcessEvent: %v", err)
+	}
+
+	// the process dies while the next event is running the waiting state
+	eventID, _ := store.SaveEvent(ctx, Event{TargetID: ed.ID()})
+	logID, _ := store.SaveLog(ctx, Log{TargetID: ed.ID(), EventID: eventID, CurrentStateName: StateManualAdd})
+
+	recovery, err := NewRecovery(RecoveryConfig[*eventData]{
+		FSM: fsm,
+		Load: func(ctx context.Context, targetID string) (TargetData[*eventData], error) {
+			return &ed, nil
+		},
+		Threshold: time.Millisecond,
+		Resume:    true,
+	})
+	if err != nil {
+		t.Fatalf("NewRecovery: %v", err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	runs, err := recovery.Sweep(ctx)
+	if err != nil {
+		t.Fatalf("Sweep: %v", err)
+	}
+
+	if len(runs) != 1 || runs[0].Log.ID != logID || !runs[0].Resumed || runs[0].Err != nil {
+		t.Fatalf("expected the resumed run of log %s, got %+v", logID, runs)
+	}
+
+	if runs[0].Log.CurrentResultStatus != ResultStatusAbandoned {
+		t.Fatalf("expected the log marked as abandoned, got %s", runs[0].Log.CurrentResultStatus)
+	}
+
+	if ed.GetState() != StatePrintResult {
+		t.Fatalf("expected state %s, got %s", StatePrintResult, ed.GetState())
+	}
+
+	if runs, err = recovery.Sweep(ctx); err != nil || len(runs) != 0 {
+		t.Fatalf("expected no abandoned runs, got %d, %v", len(runs), err)
+	}
+}
@@ -0,0 +1,195 @@
+package event_fsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	lockKeyPrefix     = "fsm:lock:"
+	defaultLockTTL    = time.Second * 30
+	lockRetryInterval = time.Millisecond * 50
+)
+
+var (
+	// acquireLockScript sets the lock key if it is not set and returns the next fencing token,
+	// the microseconds of the Redis clock or the last token plus one, see nextLockToken.
+	// 0 is returned if the lock is held
+	acquireLockScript = redis.NewScript(`
+		if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+			local time = redis.call("TIME")
+			local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+			if tonumber(redis.call("GET", KEYS[2]) or "0") < now then
+				redis.call("SET", KEYS[2], string.format("%.0f", now - 1))
+			end
+			return redis.call("INCR", KEYS[2])
+		end
+		return 0
+	`)
+
+	// renewLockScript prolongs the lease if the lock is still held by the caller
+	renewLockScript = redis.NewScript(`
+		if redis.call("GET", KEYS[1]) == ARGV[1] then
+			return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+		end
+		return 0
+	`)
+
+	// releaseLockScript deletes the lock key if the lock is still held by the caller
+	releaseLockScript = redis.NewScript(`
+		if redis.call("GET", KEYS[1]) == ARGV[1] then
+			return redis.call("DEL", KEYS[1])
+		end
+		return 0
+	`)
+)
+
+var _ Locker = (*RedisLocker)(nil)
+
+// RedisLocker is the distributed Locker based on Redis.
+// The lock is a lease which is renewed in background while it is held.
+type RedisLocker struct {
+	rdb redis.UniversalClient
+
+	keyPrefix string
+	ttl       time.Duration
+}
+
+// NewRedisLocker creates a new RedisLocker.
+// keyPrefix is prepended to the lock keys, ttl is the lease of the lock, 30 seconds if zero.
+func NewRedisLocker(rdb redis.UniversalClient, keyPrefix string, ttl time.Duration) *RedisLocker {
+	if ttl <= 0 {
+		ttl = defaultLockTTL
+	}
+
+	return &RedisLocker{
+		rdb:       rdb,
+		keyPrefix: keyPrefix,
+		ttl:       ttl,
+	}
+}
+
+func (l *RedisLocker) Lock(ctx context.Context, key string) (Lock, error) {
+	for {
+		lock, err := l.TryLock(ctx, key)
+		if !errors.Is(err, ErrTargetBusy) {
+			return lock, err
+		}
+
+		select {
+		case <-time.After(lockRetryInterval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (l *RedisLocker) TryLock(ctx context.Context, key string) (Lock, error) {
+	// the hash tag keeps both keys in one slot of Redis Cluster
+	lockKey := l.keyPrefix + "{" + key + "}"
+	value := uuid.NewString()
+
+	token, err := acquireLockScript.Run(
+		ctx, l.rdb, []string{lockKey, lockKey + ":token"}, value, l.ttl.Milliseconds(),
+	).Int64()
+	if err != nil {
+		return nil, fmt.Errorf("acquireLockScript.Run: %w", err)
+	}
+
+	if token == 0 {
+		return nil, ErrTargetBusy
+	}
+
+	lock := &redisLock{
+		locker: l,
+		key:    lockKey,
+		value:  value,
+		token:  token,
+		lost:   make(chan struct{}),
+		stop:   make(chan struct{}),
+	}
+
+	lock.wg.Add(1)
+	go lock.renew()
+
+	return lock, nil
+}
+
+type redisLock struct {
+	locker *RedisLocker
+
+	key   string
+	value string
+	token int64
+
+	lost chan struct{}
+	stop chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+}
+
+func (l *redisLock) Token() int64 {
+	return l.token
+}
+
+func (l *redisLock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+func (l *redisLock) Unlock(ctx context.Context) error {
+	var err error
+
+	l.once.Do(func() {
+		close(l.stop)
+		l.wg.Wait()
+
+		if err = releaseLockScript.Run(ctx, l.locker.rdb, []string{l.key}, l.value).Err(); err != nil {
+			err = fmt.Errorf("releaseLockScript.Run: %w", err)
+		}
+	})
+
+	return err
+}
+
+// renew prolongs the lease until the lock is released.
+// The lock is lost when the key is taken by someone else or the lease expires without renewal.
+func (l *redisLock) renew() {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(l.locker.ttl / 3)
+	defer ticker.Stop()
+
+	renewedAt := time.Now()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), l.locker.ttl/3)
+		ok, err := renewLockScript.Run(
+			ctx, l.locker.rdb, []string{l.key}, l.value, l.locker.ttl.Milliseconds(),
+		).Int64()
+		cancel()
+
+		switch {
+		case err == nil && ok == 1:
+			renewedAt = time.Now()
+			continue
+		case err != nil && time.Since(renewedAt) < l.locker.ttl:
+			// try again on the next tick while the lease is still valid
+			continue
+		}
+
+		close(l.lost)
+		return
+	}
+}
@@ -0,0 +1,56 @@
+package event_fsm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var _ Publisher = (*RedisStreamPublisher)(nil)
+
+// RedisStreamPublisher is the Publisher which appends the outbox entries to the Redis stream.
+// Every message has the fields "id", "target_id" and "data", the entry encoded as JSON.
+type RedisStreamPublisher struct {
+	rdb redis.UniversalClient
+
+	stream string
+	maxLen int64
+}
+
+// NewRedisStreamPublisher creates a new RedisStreamPublisher.
+// maxLen approximately caps the length of the stream, the stream is not trimmed if zero.
+func NewRedisStreamPublisher(rdb redis.UniversalClient, stream string, maxLen int64) *RedisStreamPublisher {
+	return &RedisStreamPublisher{
+		rdb:    rdb,
+		stream: stream,
+		maxLen: maxLen,
+	}
+}
+
+func (p *RedisStreamPublisher) Publish(ctx context.Context, entry OutboxEntry) error {
+	data, err := json.Marshal(outboxEntryToDTO(entry))
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %w", err)
+	}
+
+	args := &redis.XAddArgs{
+		Stream: p.stream,
+		Values: map[string]any{
+			"id":        entry.ID,
+			"target_id": entry.TargetID,
+			"data":      data,
+		},
+	}
+
+	if p.maxLen > 0 {
+		args.MaxLen, args.Approx = p.maxLen, true
+	}
+
+	if err = p.rdb.XAdd(ctx, args).Err(); err != nil {
+		return fmt.Errorf("rdb.XAdd: %w", err)
+	}
+
+	return nil
+}
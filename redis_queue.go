@@ -0,0 +1,320 @@
+package event_fsm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisQueueTargetKey is the suffix of the keys of the lists of the event IDs of the targets
+const redisQueueTargetKey = ":target:"
+
+var (
+	// enqueueEventScript adds the message to the stream and its ID to the list of the target
+	enqueueEventScript = redis.NewScript(`
+		local id = redis.call("XADD", KEYS[1], "*", "target_id", ARGV[1], "data", ARGV[2])
+		redis.call("RPUSH", KEYS[2], id)
+		return id
+	`)
+
+	// deleteEventScript acknowledges and deletes the message and removes its ID from the list of the target
+	deleteEventScript = redis.NewScript(`
+		redis.call("XACK", KEYS[1], ARGV[1], ARGV[2])
+		redis.call("XDEL", KEYS[1], ARGV[2])
+		redis.call("LREM", KEYS[2], 1, ARGV[2])
+		return 1
+	`)
+)
+
+var _ QueueStore = (*RedisStreamQueue)(nil)
+
+// RedisStreamQueue is the QueueStore based on the Redis stream consumed by the consumer group.
+// The ID of the queued event is the ID of its stream message, limit of the claim is the number of the events.
+// The IDs of the events of every target are listed in order next to the stream, in its hash slot of Redis Cluster:
+// the event is delivered when it is the oldest one of its target or follows it in the claimed batch,
+// the later events of the target are held back pending while the oldest one is not deleted.
+// The held back events are checked again on every claim. Attempts counts the claims of the event
+// as the oldest one of its target, as the Postgres queue does.
+type RedisStreamQueue struct {
+	rdb redis.UniversalClient
+
+	stream    string
+	group     string
+	consumer  string
+	keyPrefix string
+
+	mu           sync.Mutex
+	groupCreated bool
+}
+
+// NewRedisStreamQueue creates a new RedisStreamQueue.
+// The consumer group is created on the first claim, consumer must be unique for every replica of the Worker.
+func NewRedisStreamQueue(rdb redis.UniversalClient, stream, group, consumer string) *RedisStreamQueue {
+	// the hash tag of the stream, or the whole stream, puts the lists of the targets in its hash slot
+	keyPrefix := "{" + stream + "}" + redisQueueTargetKey
+	if start := strings.IndexByte(stream, '{'); start >= 0 && strings.IndexByte(stream[start+1:], '}') > 0 {
+		keyPrefix = stream + redisQueueTargetKey
+	}
+
+	return &RedisStreamQueue{
+		rdb:       rdb,
+		stream:    stream,
+		group:     group,
+		consumer:  consumer,
+		keyPrefix: keyPrefix,
+	}
+}
+
+func (q *RedisStreamQueue) EnqueueEvent(ctx context.Context, event QueuedEvent) (string, error) {
+	event.CreatedAt = time.Now()
+
+	dto, err := queuedEventToDTO(event)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(dto)
+	if err != nil {
+		return "", fmt.Errorf("json.Marshal: %w", err)
+	}
+
+	id, err := enqueueEventScript.Run(
+		ctx, q.rdb, []string{q.stream, q.targetKey(event.TargetID)}, event.TargetID, data,
+	).Text()
+	if err != nil {
+		return "", fmt.Errorf("enqueueEventScript.Run: %w", err)
+	}
+
+	return id, nil
+}
+
+// redisClaim is the state of one claim of RedisStreamQueue
+type redisClaim struct {
+	events []QueuedEvent
+
+	// delivered is true for the targets whose events are delivered by the claim, false for the held back ones
+	delivered map[string]bool
+}
+
+// ClaimQueuedEvents reclaims the events not deleted within the visibility timeout first,
+// then reads the new ones. The events of the targets whose oldest event is not claimed are held back.
+func (q *RedisStreamQueue) ClaimQueuedEvents(ctx context.Context, limit int, visibility time.Duration) ([]QueuedEvent, error) {
+	if err := q.createGroup(ctx); err != nil {
+		return nil, err
+	}
+
+	c := &redisClaim{delivered: make(map[string]bool)}
+
+	for start := "0-0"; len(c.events) < limit; {
+		msgs, next, err := q.rdb.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   q.stream,
+			Group:    q.group,
+			Consumer: q.consumer,
+			MinIdle:  visibility,
+			Start:    start,
+			Count:    int64(limit - len(c.events)),
+		}).Result()
+		if err != nil {
+			return nil, fmt.Errorf("rdb.XAutoClaim: %w", err)
+		}
+
+		if err = q.claim(ctx, c, msgs, visibility, true); err != nil {
+			return nil, err
+		}
+
+		if next == "0-0" {
+			break
+		}
+
+		start = next
+	}
+
+	if len(c.events) >= limit {
+		return c.events, nil
+	}
+
+	// the new messages are newer than the reclaimed ones
+	streams, err := q.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    q.group,
+		Consumer: q.consumer,
+		Streams:  []string{q.stream, ">"},
+		Count:    int64(limit - len(c.events)),
+		Block:    -1,
+	}).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("rdb.XReadGroup: %w", err)
+	}
+
+	for _, stream := range streams {
+		if err = q.claim(ctx, c, stream.Messages, visibility, false); err != nil {
+			return nil, err
+		}
+	}
+
+	return c.events, nil
+}
+
+// claim delivers the claimed messages, oldest first, of the targets whose oldest event is among them.
+// The claims of the rest are not counted: the held back messages are left pending and visible to the next claim.
+func (q *RedisStreamQueue) claim(ctx context.Context, c *redisClaim, msgs []redis.XMessage, visibility time.Duration, reclaimed bool) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	var (
+		pipe       = q.rdb.Pipeline()
+		heads      = make(map[string]*redis.StringCmd)
+		deliveries = make([]*redis.XPendingExtCmd, len(msgs))
+		events     = make([]QueuedEvent, 0, len(msgs))
+	)
+
+	for i, msg := range msgs {
+		event, err := q.toQueuedEvent(msg)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := c.delivered[event.TargetID]; !ok && heads[event.TargetID] == nil {
+			heads[event.TargetID] = pipe.LIndex(ctx, q.targetKey(event.TargetID), 0)
+		}
+
+		if reclaimed {
+			deliveries[i] = pipe.XPendingExt(ctx, &redis.XPendingExtArgs{
+				Stream: q.stream,
+				Group:  q.group,
+				Start:  msg.ID,
+				End:    msg.ID,
+				Count:  1,
+			})
+		}
+
+		events = append(events, event)
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return fmt.Errorf("pipe.Exec: %w", err)
+	}
+
+	reset := q.rdb.Pipeline()
+
+	for i, event := range events {
+		// the new message is delivered once
+		event.Attempts = 1
+		if reclaimed {
+			pending, err := deliveries[i].Result()
+			if err != nil {
+				return fmt.Errorf("pipe.XPendingExt: %w", err)
+			}
+
+			if len(pending) > 0 {
+				event.Attempts = int(pending[0].RetryCount)
+			}
+		}
+
+		delivered, ok := c.delivered[event.TargetID]
+		if !ok {
+			// the list of the target is missing when the message is deleted meanwhile
+			head, err := heads[event.TargetID].Result()
+			if err != nil && !errors.Is(err, redis.Nil) {
+				return fmt.Errorf("pipe.LIndex: %w", err)
+			}
+
+			delivered = head == "" || head == event.ID
+			c.delivered[event.TargetID] = delivered
+
+			if delivered {
+				c.events = append(c.events, event)
+				continue
+			}
+		}
+
+		// the held back message is visible to the next claim, the one following the oldest event
+		// is hidden with it
+		idle := visibility
+		if delivered {
+			idle = 0
+		}
+
+		event.Attempts--
+		reset.Do(
+			ctx, "XCLAIM", q.stream, q.group, q.consumer, 0, event.ID,
+			"IDLE", idle.Milliseconds(), "RETRYCOUNT", event.Attempts, "JUSTID",
+		)
+
+		if delivered {
+			c.events = append(c.events, event)
+		}
+	}
+
+	if reset.Len() == 0 {
+		return nil
+	}
+
+	if _, err := reset.Exec(ctx); err != nil {
+		return fmt.Errorf("reset.Exec: %w", err)
+	}
+
+	return nil
+}
+
+func (q *RedisStreamQueue) DeleteQueuedEvent(ctx context.Context, id string) error {
+	msgs, err := q.rdb.XRange(ctx, q.stream, id, id).Result()
+	if err != nil {
+		return fmt.Errorf("rdb.XRange: %w", err)
+	}
+
+	var targetID string
+	if len(msgs) > 0 {
+		targetID, _ = msgs[0].Values["target_id"].(string)
+	}
+
+	err = deleteEventScript.Run(ctx, q.rdb, []string{q.stream, q.targetKey(targetID)}, q.group, id).Err()
+	if err != nil {
+		return fmt.Errorf("deleteEventScript.Run: %w", err)
+	}
+
+	return nil
+}
+
+// createGroup creates the consumer group reading the stream from the beginning unless it exists
+func (q *RedisStreamQueue) createGroup(ctx context.Context) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.groupCreated {
+		return nil
+	}
+
+	err := q.rdb.XGroupCreateMkStream(ctx, q.stream, q.group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("rdb.XGroupCreateMkStream: %w", err)
+	}
+
+	q.groupCreated = true
+
+	return nil
+}
+
+// targetKey returns the key of the list of the event IDs of the target
+func (q *RedisStreamQueue) targetKey(targetID string) string {
+	return q.keyPrefix + targetID
+}
+
+func (q *RedisStreamQueue) toQueuedEvent(msg redis.XMessage) (QueuedEvent, error) {
+	data, _ := msg.Values["data"].(string)
+
+	var dto queuedEventDto
+	if err := json.Unmarshal([]byte(data), &dto); err != nil {
+		return QueuedEvent{}, fmt.Errorf("json.Unmarshal of message %s: %w", msg.ID, err)
+	}
+
+	dto.ID = msg.ID
+
+	return dto.toQueuedEvent(), nil
+}
@@ -0,0 +1,91 @@
+package event_fsm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRedisStreamQueueOrder(t *testing.T) {
+	ctx := context.Background()
+
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	first := NewRedisStreamQueue(rdb, "events", "workers", "first")
+	second := NewRedisStreamQueue(rdb, "events", "workers", "second")
+
+	enqueue := func(targetID, name string) string {
+		id, err := first.EnqueueEvent(ctx, QueuedEvent{TargetID: targetID, Payload: EventPayload{Name: name}})
+		if err != nil {
+			t.Fatalf("EnqueueEvent: %v", err)
+		}
+
+		return id
+	}
+
+	claim := func(q *RedisStreamQueue, visibility time.Duration, want ...string) []QueuedEvent {
+		events, err := q.ClaimQueuedEvents(ctx, 10, visibility)
+		if err != nil {
+			t.Fatalf("ClaimQueuedEvents: %v", err)
+		}
+
+		names := make([]string, 0, len(events))
+		for _, e := range events {
+			names = append(names, e.Payload.Name)
+		}
+
+		if len(names) != len(want) {
+			t.Fatalf("expected the events %v, got %v", want, names)
+		}
+
+		for i := range want {
+			if names[i] != want[i] {
+				t.Fatalf("expected the events %v, got %v", want, names)
+			}
+		}
+
+		return events
+	}
+
+	a1 := enqueue("a", "a1")
+	enqueue("a", "a2")
+	b1 := enqueue("b", "b1")
+
+	// the event following the oldest one of its target is not counted
+	events := claim(first, time.Minute, "a1", "a2", "b1")
+	if events[0].Attempts != 1 || events[1].Attempts != 0 || events[2].Attempts != 1 {
+		t.Fatalf("expected the attempts 1, 0 and 1, got %+v", events)
+	}
+
+	if err := first.DeleteQueuedEvent(ctx, b1); err != nil {
+		t.Fatalf("DeleteQueuedEvent: %v", err)
+	}
+
+	enqueue("a", "a3")
+	enqueue("b", "b2")
+
+	// a1 is being processed by the first replica, a3 is held back
+	claim(second, time.Minute, "b2")
+
+	time.Sleep(5 * time.Millisecond)
+
+	// a1 is redelivered with the events of its target in order, the held back a3 is not counted
+	events = claim(second, time.Millisecond, "a1", "a2", "a3", "b2")
+	if events[0].Attempts != 2 || events[1].Attempts != 0 || events[2].Attempts != 0 || events[3].Attempts != 2 {
+		t.Fatalf("expected the attempts 2, 0, 0 and 2, got %+v", events)
+	}
+
+	if err := second.DeleteQueuedEvent(ctx, a1); err != nil {
+		t.Fatalf("DeleteQueuedEvent: %v", err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	// a2 is the oldest event of its target now
+	events = claim(first, time.Millisecond, "a2", "a3", "b2")
+	if events[0].Attempts != 1 || events[1].Attempts != 0 {
+		t.Fatalf("expected the attempts 1 and 0, got %+v", events)
+	}
+}
@@ -0,0 +1,119 @@
+package event_fsm
+
+import (
+	"fmt"
+	"sync"
+)
+
+// defaultRegistry is used by the package-level NewStateName and NewResultStatus
+var defaultRegistry = NewRegistry()
+
+// Registry keeps the state names and the result statuses of one state machine.
+// It is safe for concurrent use.
+//
+// The JSON and SQL methods of StateName and ResultStatus know only the default registry,
+// the names of the own registry must be kept as strings and parsed with ParseStateName and ParseResultStatus.
+type Registry struct {
+	mu sync.RWMutex
+
+	stateNames     map[string]StateName
+	resultStatuses map[string]ResultStatus
+}
+
+func NewRegistry() *Registry {
+	r := &Registry{
+		stateNames:     make(map[string]StateName),
+		resultStatuses: make(map[string]ResultStatus, len(builtinResultStatuses)),
+	}
+
+	for _, status := range builtinResultStatuses {
+		r.resultStatuses[status.String()] = status
+	}
+
+	return r
+}
+
+// DefaultRegistry returns the registry of the package-level NewStateName and NewResultStatus,
+// it is used by the state detectors created with NewStateDetector
+func DefaultRegistry() *Registry {
+	return defaultRegistry
+}
+
+// NewStateName registers the state name
+func (r *Registry) NewStateName(name string) StateName {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if sn, ok := r.stateNames[name]; ok {
+		return sn
+	}
+
+	sn := StateName(name)
+	r.stateNames[name] = sn
+
+	return sn
+}
+
+// NewResultStatus registers the result status
+func (r *Registry) NewResultStatus(status string) ResultStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if rs, ok := r.resultStatuses[status]; ok {
+		return rs
+	}
+
+	rs := ResultStatus(status)
+	r.resultStatuses[status] = rs
+
+	return rs
+}
+
+// ParseStateName returns the registered state name
+func (r *Registry) ParseStateName(name string) (StateName, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	sn, ok := r.stateNames[name]
+	if !ok {
+		return "", fmt.Errorf("state name %s: %w", name, ErrStateNameNotFound)
+	}
+
+	return sn, nil
+}
+
+// ParseResultStatus returns the registered result status
+func (r *Registry) ParseResultStatus(status string) (ResultStatus, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rs, ok := r.resultStatuses[status]
+	if !ok {
+		return "", fmt.Errorf("result status %s not found", status)
+	}
+
+	return rs, nil
+}
+
+func (r *Registry) hasStateName(name string) bool {
+	_, err := r.ParseStateName(name)
+	return err == nil
+}
+
+func (r *Registry) hasResultStatus(status string) bool {
+	_, err := r.ParseResultStatus(status)
+	return err == nil
+}
+
+// checkStateName checks the state name is not empty and is registered
+func (r *Registry) checkStateName(sn StateName) (bool, error) {
+	if sn.String() == "" {
+		return false, ErrEmptyStateName
+	}
+
+	if !r.hasStateName(sn.String()) {
+		return false, ErrStateNameNotFound
+	}
+
+	return true, nil
+}
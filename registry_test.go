@@ -0,0 +1,49 @@
+package event_fsm
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	first, second := NewRegistry(), NewRegistry()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			first.NewStateName("scoped_" + strconv.Itoa(i))
+			first.NewResultStatus("scoped_" + strconv.Itoa(i))
+		}(i)
+	}
+	wg.Wait()
+
+	sn, err := first.ParseStateName("scoped_1")
+	if err != nil {
+		t.Fatalf("ParseStateName: %v", err)
+	}
+
+	if _, err = second.ParseStateName("scoped_1"); !errors.Is(err, ErrStateNameNotFound) {
+		t.Fatalf("expected ErrStateNameNotFound, got %v", err)
+	}
+
+	if _, err = DefaultRegistry().ParseStateName("scoped_1"); !errors.Is(err, ErrStateNameNotFound) {
+		t.Fatalf("expected the default registry not to know the scoped state name, got %v", err)
+	}
+
+	if _, err = second.ParseResultStatus(ResultStatusOk.String()); err != nil {
+		t.Fatalf("expected the builtin result status in every registry, got %v", err)
+	}
+
+	defer func() {
+		if r := recover(); r != ErrStateNameNotFound {
+			t.Fatalf("expected panic with ErrStateNameNotFound, got %v", r)
+		}
+	}()
+
+	NewStateDetectorWithRegistry[*eventData](second).NewState(sn, &stateFirstCheck{}, StateTypeTransition)
+}
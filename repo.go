@@ -1,17 +1,25 @@
 package event_fsm
 
 import (
+	"cmp"
 	"context"
 	"database/sql"
 	"fmt"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/jmoiron/sqlx"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type dbStore struct {
 	db *sqlx.DB
 
+	// tracer creates the spans of the queries if Config.TracerProvider is set
+	tracer trace.Tracer
+
 	stateRepo
 }
 
@@ -25,12 +33,93 @@ func newDBStore(db *sqlx.DB) *dbStore {
 
 type stateRepo struct {
 	store *dbStore
+
+	schema  Schema
+	queries sync.Map
 }
 
-func newRepo(store *dbStore) *stateRepo {
+func newRepo(store *dbStore, schema Schema) *stateRepo {
 	return &stateRepo{
-		store: store,
+		store:  store,
+		schema: schema,
+	}
+}
+
+// q returns the query addressing the tables of the repository schema
+func (s *stateRepo) q(query string) string {
+	if q, ok := s.queries.Load(query); ok {
+		return q.(string)
+	}
+
+	q := s.schema.qualify(query)
+	s.queries.Store(query, q)
+
+	return q
+}
+
+// client returns the transaction of the context if there is one, the database pool otherwise
+func (s *stateRepo) client(ctx context.Context) sqlClient {
+	var client sqlClient = s.store.db
+	if tx, ok := TxFromContext(ctx); ok {
+		client = tx
+	}
+
+	if s.store.tracer != nil {
+		return tracedClient{sqlClient: client, tracer: s.store.tracer}
+	}
+
+	return client
+}
+
+// fenced runs the write of the target in one transaction with the check of the lock token of the context,
+// the write is rejected with ErrLockLost if the greater token of the target is recorded.
+// The fence of the target stays locked until the transaction ends, so the next holder of the lock
+// waits for the write of the previous one to finish.
+func (s *stateRepo) fenced(ctx context.Context, targetID string, write func(ctx context.Context) error) error {
+	token, ok := LockToken(ctx)
+	if !ok {
+		return write(ctx)
+	}
+
+	return runInTx(ctx, s.store.db, func(ctx context.Context) error {
+		if err := s.fence(ctx, targetID, token); err != nil {
+			return err
+		}
+
+		return write(ctx)
+	})
+}
+
+// fence records the lock token of the target unless the greater one is recorded
+func (s *stateRepo) fence(ctx context.Context, targetID string, token int64) error {
+	const query = `INSERT INTO fsm_fences AS f (
+						target_id,
+						token,
+						updated_at
+					) VALUES (
+						$1,
+						$2,
+						now()
+					) ON CONFLICT (target_id) DO UPDATE
+					SET token = EXCLUDED.token,
+						updated_at = now()
+					WHERE f.token <= EXCLUDED.token`
+
+	res, err := s.client(ctx).ExecContext(ctx, s.q(query), targetID, token)
+	if err != nil {
+		return fmt.Errorf("fence: %w", err)
 	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("fence: %w", err)
+	}
+
+	if n == 0 {
+		return fmt.Errorf("lock token %d of target %s is stale: %w", token, targetID, ErrLockLost)
+	}
+
+	return nil
 }
 
 func (s *stateRepo) createLog(ctx context.Context, log Log) (string, error) {
@@ -38,19 +127,25 @@ func (s *stateRepo) createLog(ctx context.Context, log Log) (string, error) {
 						target_id,
 						event_id,
 						current_state,
+						attempt,
+						trace_id,
+						span_id,
 						created_at,
 						updated_at
                   	) VALUES (
 						:target_id,
 					  	:event_id,
 						:current_state,
+						:attempt,
+						:trace_id,
+						:span_id,
 						now(), 
 						now()
 					) RETURNING id`
 
 	dto := logToDTO(log)
 	var id string
-	rows, err := s.store.db.NamedQueryContext(ctx, query, dto)
+	rows, err := sqlx.NamedQueryContext(ctx, s.client(ctx), s.q(query), dto)
 	if err != nil {
 		return "", err
 	}
@@ -73,6 +168,10 @@ func (s *stateRepo) createFullLog(ctx context.Context, log Log) (string, error)
 						event_id,
 						current_state,
 						current_result_status,
+						error,
+						attempt,
+						trace_id,
+						span_id,
 						created_at,
 						updated_at
 				  	) VALUES (
@@ -80,13 +179,17 @@ func (s *stateRepo) createFullLog(ctx context.Context, log Log) (string, error)
 					  	:event_id,
 						:current_state,
 						:current_result_status,
+						NULLIF(:error, ''),
+						:attempt,
+						:trace_id,
+						:span_id,
 						now(), 
 						now()
 					) RETURNING id`
 
 	dto := logToDTO(log)
 	var id string
-	rows, err := s.store.db.NamedQueryContext(ctx, query, dto)
+	rows, err := sqlx.NamedQueryContext(ctx, s.client(ctx), s.q(query), dto)
 	if err != nil {
 		return "", err
 	}
@@ -106,11 +209,12 @@ func (s *stateRepo) createFullLog(ctx context.Context, log Log) (string, error)
 func (s *stateRepo) updateLog(ctx context.Context, log Log) error {
 	const query = `UPDATE fsm_target_logs
 					SET current_result_status = :current_result_status,
+						error = NULLIF(:error, ''),
 						updated_at = now()
 					WHERE id = :id`
 
 	dto := logToDTO(log)
-	_, err := s.store.db.NamedExecContext(ctx, query, dto)
+	_, err := s.client(ctx).NamedExecContext(ctx, s.q(query), dto)
 	if err != nil {
 		return err
 	}
@@ -118,6 +222,202 @@ func (s *stateRepo) updateLog(ctx context.Context, log Log) error {
 	return nil
 }
 
+// claimAbandonedLogs marks the logs without result status, older than the duration, as abandoned,
+// the logs locked by the concurrent claims are skipped
+func (s *stateRepo) claimAbandonedLogs(ctx context.Context, duration time.Duration, limit int) ([]Log, error) {
+	const query = `
+		UPDATE fsm_target_logs
+		SET current_result_status = $3,
+			error = $4,
+			updated_at = now()
+		WHERE id IN (
+			SELECT id
+			FROM fsm_target_logs
+			WHERE current_result_status IS NULL AND created_at < now() - make_interval(secs => $1)
+			ORDER BY created_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING
+			id,
+			target_id,
+			event_id,
+			current_state,
+			current_result_status,
+			COALESCE(error, '') AS error,
+			attempt,
+			trace_id,
+			span_id,
+			created_at,
+			updated_at`
+
+	var dtos []logDto
+	if err := s.client(ctx).SelectContext(
+		ctx, &dtos, s.q(query), duration.Seconds(), limit, ResultStatusAbandoned.String(), abandonedLogError,
+	); err != nil {
+		return nil, err
+	}
+
+	// RETURNING doesn't keep the order of the subquery
+	slices.SortFunc(dtos, func(a, b logDto) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+
+	logs := make([]Log, 0, len(dtos))
+	for i := range dtos {
+		logs = append(logs, dtos[i].toLog())
+	}
+
+	return logs, nil
+}
+
+func (s *stateRepo) getLogsByTargetID(ctx context.Context, targetID string, filter HistoryFilter) (LogPage, error) {
+	w, err := newHistoryWhere(targetID, filter.From, filter.To, filter.Cursor)
+	if err != nil {
+		return LogPage{}, err
+	}
+
+	if len(filter.States) > 0 {
+		states := make([]string, 0, len(filter.States))
+		for _, state := range filter.States {
+			states = append(states, state.String())
+		}
+
+		w.add("current_state = ANY(?)", states)
+	}
+
+	query := `SELECT
+					id,
+					target_id,
+					event_id,
+					current_state,
+					COALESCE(current_result_status, '') AS current_result_status,
+					COALESCE(error, '') AS error,
+					attempt,
+					trace_id,
+					span_id,
+					created_at,
+					updated_at
+				FROM fsm_target_logs
+				WHERE ` + w.String() + `
+				ORDER BY created_at DESC, id DESC
+				LIMIT ?`
+
+	var dtos []logDto
+	query = s.q(sqlx.Rebind(sqlx.DOLLAR, query))
+	if err = s.client(ctx).SelectContext(ctx, &dtos, query, w.withArgs(filter.Limit+1)...); err != nil {
+		return LogPage{}, err
+	}
+
+	var page LogPage
+	if len(dtos) > filter.Limit {
+		dtos = dtos[:filter.Limit]
+		last := dtos[len(dtos)-1]
+		page.NextCursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+
+	page.Logs = make([]Log, 0, len(dtos))
+	for i := range dtos {
+		page.Logs = append(page.Logs, dtos[i].toLog())
+	}
+
+	return page, nil
+}
+
+func (s *stateRepo) getEventsByTargetID(ctx context.Context, targetID string, filter EventsFilter) (EventPage, error) {
+	w, err := newHistoryWhere(targetID, filter.From, filter.To, filter.Cursor)
+	if err != nil {
+		return EventPage{}, err
+	}
+
+	query := `SELECT
+					id,
+					target_id,
+					current_state,
+					last_result_status,
+					meta_info,
+					payload,
+					COALESCE(idempotency_key, '') AS idempotency_key,
+					created_at,
+					updated_at
+				FROM fsm_target_events
+				WHERE ` + w.String() + `
+				ORDER BY created_at DESC, id DESC
+				LIMIT ?`
+
+	var dtos []eventDto
+	query = s.q(sqlx.Rebind(sqlx.DOLLAR, query))
+	if err = s.client(ctx).SelectContext(ctx, &dtos, query, w.withArgs(filter.Limit+1)...); err != nil {
+		return EventPage{}, err
+	}
+
+	var page EventPage
+	if len(dtos) > filter.Limit {
+		dtos = dtos[:filter.Limit]
+		last := dtos[len(dtos)-1]
+		page.NextCursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+
+	page.Events = make([]Event, 0, len(dtos))
+	for i := range dtos {
+		event, err := dtos[i].toEvent()
+		if err != nil {
+			return EventPage{}, err
+		}
+
+		page.Events = append(page.Events, event)
+	}
+
+	return page, nil
+}
+
+// historyWhere builds the WHERE clause of the history queries with '?' placeholders
+type historyWhere struct {
+	conds []string
+	args  []any
+}
+
+func newHistoryWhere(targetID string, from, to time.Time, cursor string) (*historyWhere, error) {
+	w := &historyWhere{}
+	w.add("target_id = ?", targetID)
+
+	if !from.IsZero() {
+		w.add("created_at >= ?", from)
+	}
+
+	if !to.IsZero() {
+		w.add("created_at < ?", to)
+	}
+
+	cursorTime, cursorID, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	if cursorID != "" {
+		w.add("(created_at, id) < (?, ?)", cursorTime, cursorID)
+	}
+
+	return w, nil
+}
+
+func (w *historyWhere) add(cond string, args ...any) {
+	w.conds = append(w.conds, cond)
+	w.args = append(w.args, args...)
+}
+
+func (w *historyWhere) String() string {
+	if len(w.conds) == 0 {
+		return "TRUE"
+	}
+
+	return strings.Join(w.conds, " AND ")
+}
+
+func (w *historyWhere) withArgs(args ...any) []any {
+	return append(w.args[:len(w.args):len(w.args)], args...)
+}
+
 // deleteLogs deletes logs older than the specified duration,
 // keeping the last 'keepCount' logs for each 'id' (source).
 func (s *stateRepo) deleteLogs(ctx context.Context, duration time.Duration, keepCount int) error {
@@ -130,13 +430,13 @@ func (s *stateRepo) deleteLogs(ctx context.Context, duration time.Duration, keep
 					id,
 					ROW_NUMBER() OVER (PARTITION BY target_id ORDER BY created_at DESC) as rn
 				FROM fsm_target_logs
-				WHERE created_at < NOW() - $1
+				WHERE created_at < NOW() - make_interval(secs => $1)
 			) as sub
 			WHERE rn > $2
 		);
 	`
 
-	_, err := s.store.db.ExecContext(ctx, query, duration, keepCount)
+	_, err := s.client(ctx).ExecContext(ctx, s.q(query), duration.Seconds(), keepCount)
 	if err != nil {
 		return fmt.Errorf("failed to delete logs: %w", err)
 	}
@@ -159,42 +459,106 @@ func (s *stateRepo) getEventByID(ctx context.Context, id string) (Event, error)
 	const query = `	SELECT
 						id,
 						target_id,
+						current_state,
 						last_result_status,
 						meta_info,
+						payload,
+						COALESCE(idempotency_key, '') AS idempotency_key,
 						created_at,
 						updated_at
 					FROM fsm_target_events
 					WHERE id = $1`
 
 	var dto eventDto
-	err := s.store.db.GetContext(ctx, &dto, query, id)
+	err := s.client(ctx).GetContext(ctx, &dto, s.q(query), id)
+	if err != nil {
+		return Event{}, err
+	}
+
+	return dto.toEvent()
+}
+
+func (s *stateRepo) getEventByIdempotencyKey(ctx context.Context, targetID, key string) (Event, error) {
+	const query = `	SELECT
+						id,
+						target_id,
+						current_state,
+						last_result_status,
+						meta_info,
+						payload,
+						idempotency_key,
+						created_at,
+						updated_at
+					FROM fsm_target_events
+					WHERE target_id = $1 AND idempotency_key = $2`
+
+	var dto eventDto
+	err := s.client(ctx).GetContext(ctx, &dto, s.q(query), targetID, key)
 	if err != nil {
 		return Event{}, err
 	}
 
-	return dto.toEvent(), nil
+	return dto.toEvent()
+}
+
+func (s *stateRepo) expireIdempotencyKey(ctx context.Context, targetID, key string, duration time.Duration) error {
+	const query = `
+		UPDATE fsm_target_events
+		SET idempotency_key = NULL
+		WHERE target_id = $1 AND idempotency_key = $2 AND created_at < now() - make_interval(secs => $3)`
+
+	_, err := s.client(ctx).ExecContext(ctx, s.q(query), targetID, key, duration.Seconds())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *stateRepo) expireIdempotencyKeys(ctx context.Context, duration time.Duration) error {
+	const query = `
+		UPDATE fsm_target_events
+		SET idempotency_key = NULL
+		WHERE idempotency_key IS NOT NULL AND created_at < now() - make_interval(secs => $1)`
+
+	_, err := s.client(ctx).ExecContext(ctx, s.q(query), duration.Seconds())
+	if err != nil {
+		return err
+	}
+
+	return nil
 }
 
 func (s *stateRepo) createEvent(ctx context.Context, event Event) (string, error) {
 	const query = `INSERT INTO fsm_target_events (
 					   	id,
 						target_id,
+						current_state,
 						last_result_status,
 						meta_info,
+						payload,
+						idempotency_key,
 						created_at,
 						updated_at
 					) VALUES (
 					    :id,
-						:entity_id,
+						:target_id,
+						:current_state,
 						:last_result_status,
 						:meta_info,
+						:payload,
+						NULLIF(:idempotency_key, ''),
 						now(),
 						now()
 					) RETURNING id`
 
-	dto := eventToDTO(event)
+	dto, err := eventToDTO(event)
+	if err != nil {
+		return "", err
+	}
+
 	var id string
-	rows, err := s.store.db.NamedQueryContext(ctx, query, dto)
+	rows, err := sqlx.NamedQueryContext(ctx, s.client(ctx), s.q(query), dto)
 	if err != nil {
 		return "", err
 	}
@@ -213,12 +577,17 @@ func (s *stateRepo) createEvent(ctx context.Context, event Event) (string, error
 
 func (s *stateRepo) updateEvent(ctx context.Context, event Event) error {
 	const query = `UPDATE fsm_target_events
-					SET last_result_status = :last_result_status,
+					SET current_state = :current_state,
+						last_result_status = :last_result_status,
 						updated_at = now()
 					WHERE id = :id`
 
-	dto := eventToDTO(event)
-	_, err := s.store.db.NamedExecContext(ctx, query, dto)
+	dto, err := eventToDTO(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client(ctx).NamedExecContext(ctx, s.q(query), dto)
 	if err != nil {
 		return err
 	}
@@ -226,6 +595,487 @@ func (s *stateRepo) updateEvent(ctx context.Context, event Event) error {
 	return nil
 }
 
+func (s *stateRepo) createScheduledEvent(ctx context.Context, event ScheduledEvent) (string, error) {
+	const query = `INSERT INTO fsm_scheduled_events (
+						target_id,
+						result_status,
+						payload,
+						event_id,
+						state,
+						run_at,
+						created_at
+					) VALUES (
+						:target_id,
+						:result_status,
+						:payload,
+						NULLIF(:event_id, '')::uuid,
+						:state,
+						:run_at,
+						now()
+					) RETURNING id`
+
+	dto, err := scheduledEventToDTO(event)
+	if err != nil {
+		return "", err
+	}
+
+	var id string
+	rows, err := sqlx.NamedQueryContext(ctx, s.client(ctx), s.q(query), dto)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		if err := rows.Scan(&id); err != nil {
+			return "", err
+		}
+	} else {
+		return "", fmt.Errorf("no rows returned")
+	}
+
+	return id, nil
+}
+
+func (s *stateRepo) deleteScheduledEvent(ctx context.Context, id string) error {
+	const query = `DELETE FROM fsm_scheduled_events WHERE id = $1`
+
+	_, err := s.client(ctx).ExecContext(ctx, s.q(query), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *stateRepo) deleteBoundScheduledEvents(ctx context.Context, targetID string, state StateName) error {
+	const query = `DELETE FROM fsm_scheduled_events WHERE target_id = $1 AND state = $2`
+
+	_, err := s.client(ctx).ExecContext(ctx, s.q(query), targetID, state.String())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// claimScheduledEvents locks the due events for the lease,
+// the events locked by the concurrent claims are skipped
+func (s *stateRepo) claimScheduledEvents(ctx context.Context, limit int, lease time.Duration) ([]ScheduledEvent, error) {
+	const query = `
+		UPDATE fsm_scheduled_events
+		SET locked_until = now() + make_interval(secs => $2),
+			attempts = attempts + 1
+		WHERE id IN (
+			SELECT id
+			FROM fsm_scheduled_events
+			WHERE run_at <= now() AND (locked_until IS NULL OR locked_until <= now())
+			ORDER BY run_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING
+			id,
+			target_id,
+			result_status,
+			payload,
+			COALESCE(event_id::text, '') AS event_id,
+			state,
+			run_at,
+			attempts,
+			created_at`
+
+	var dtos []scheduledEventDto
+	if err := s.client(ctx).SelectContext(ctx, &dtos, s.q(query), limit, lease.Seconds()); err != nil {
+		return nil, err
+	}
+
+	// RETURNING doesn't keep the order of the subquery
+	slices.SortFunc(dtos, func(a, b scheduledEventDto) int {
+		return a.RunAt.Compare(b.RunAt)
+	})
+
+	events := make([]ScheduledEvent, 0, len(dtos))
+	for i := range dtos {
+		events = append(events, dtos[i].toScheduledEvent())
+	}
+
+	return events, nil
+}
+
+func (s *stateRepo) createOutboxEntry(ctx context.Context, entry OutboxEntry) error {
+	const query = `INSERT INTO fsm_outbox (
+						target_id,
+						event_id,
+						log_id,
+						state,
+						result_status,
+						created_at
+					) VALUES (
+						:target_id,
+						:event_id,
+						:log_id,
+						:state,
+						:result_status,
+						now()
+					)`
+
+	_, err := s.client(ctx).NamedExecContext(ctx, s.q(query), outboxEntryToDTO(entry))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// claimOutboxEntries locks the entries of the targets whose oldest entry is not locked,
+// the targets locked by the concurrent claims are skipped
+func (s *stateRepo) claimOutboxEntries(ctx context.Context, limit int, lease time.Duration) ([]OutboxEntry, error) {
+	const query = `
+		UPDATE fsm_outbox
+		SET locked_until = now() + make_interval(secs => $2),
+			attempts = attempts + 1
+		WHERE target_id IN (
+			SELECT o.target_id
+			FROM fsm_outbox o
+			WHERE (o.locked_until IS NULL OR o.locked_until <= now())
+				AND NOT EXISTS (SELECT 1 FROM fsm_outbox p WHERE p.target_id = o.target_id AND p.seq < o.seq)
+			ORDER BY o.seq
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING
+			id,
+			seq,
+			target_id,
+			event_id,
+			log_id,
+			state,
+			result_status,
+			attempts,
+			created_at`
+
+	var dtos []outboxEntryDto
+	if err := s.client(ctx).SelectContext(ctx, &dtos, s.q(query), limit, lease.Seconds()); err != nil {
+		return nil, err
+	}
+
+	// RETURNING doesn't keep the order of the subquery
+	slices.SortFunc(dtos, func(a, b outboxEntryDto) int {
+		return cmp.Compare(a.Seq, b.Seq)
+	})
+
+	entries := make([]OutboxEntry, 0, len(dtos))
+	for i := range dtos {
+		entries = append(entries, dtos[i].toOutboxEntry())
+	}
+
+	return entries, nil
+}
+
+func (s *stateRepo) deleteOutboxEntries(ctx context.Context, ids []string) error {
+	const query = `DELETE FROM fsm_outbox WHERE id = ANY($1)`
+
+	_, err := s.client(ctx).ExecContext(ctx, s.q(query), ids)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *stateRepo) createQueuedEvent(ctx context.Context, event QueuedEvent) (string, error) {
+	const query = `INSERT INTO fsm_queue (
+						target_id,
+						payload,
+						idempotency_key,
+						created_at
+					) VALUES (
+						:target_id,
+						:payload,
+						:idempotency_key,
+						now()
+					) RETURNING id`
+
+	dto, err := queuedEventToDTO(event)
+	if err != nil {
+		return "", err
+	}
+
+	var id string
+	rows, err := sqlx.NamedQueryContext(ctx, s.client(ctx), s.q(query), dto)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		if err := rows.Scan(&id); err != nil {
+			return "", err
+		}
+	} else {
+		return "", fmt.Errorf("no rows returned")
+	}
+
+	return id, nil
+}
+
+// claimQueuedEvents locks the events of the targets whose oldest event is not locked,
+// the targets locked by the concurrent claims are skipped
+func (s *stateRepo) claimQueuedEvents(ctx context.Context, limit int, visibility time.Duration) ([]QueuedEvent, error) {
+	const query = `
+		UPDATE fsm_queue e
+		SET locked_until = now() + make_interval(secs => $2),
+			attempts = e.attempts + CASE
+				WHEN EXISTS (SELECT 1 FROM fsm_queue p WHERE p.target_id = e.target_id AND p.seq < e.seq) THEN 0
+				ELSE 1
+			END
+		WHERE target_id IN (
+			SELECT q.target_id
+			FROM fsm_queue q
+			WHERE (q.locked_until IS NULL OR q.locked_until <= now())
+				AND NOT EXISTS (SELECT 1 FROM fsm_queue p WHERE p.target_id = q.target_id AND p.seq < q.seq)
+			ORDER BY q.seq
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING
+			id,
+			seq,
+			target_id,
+			payload,
+			idempotency_key,
+			attempts,
+			created_at`
+
+	var dtos []queuedEventDto
+	if err := s.client(ctx).SelectContext(ctx, &dtos, s.q(query), limit, visibility.Seconds()); err != nil {
+		return nil, err
+	}
+
+	// RETURNING doesn't keep the order of the subquery
+	slices.SortFunc(dtos, func(a, b queuedEventDto) int {
+		return cmp.Compare(a.Seq, b.Seq)
+	})
+
+	events := make([]QueuedEvent, 0, len(dtos))
+	for i := range dtos {
+		events = append(events, dtos[i].toQueuedEvent())
+	}
+
+	return events, nil
+}
+
+func (s *stateRepo) deleteQueuedEvent(ctx context.Context, id string) error {
+	const query = `DELETE FROM fsm_queue WHERE id = $1`
+
+	_, err := s.client(ctx).ExecContext(ctx, s.q(query), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *stateRepo) createDeadLetter(ctx context.Context, dl DeadLetter) (string, error) {
+	const query = `INSERT INTO fsm_dead_letters (
+						target_id,
+						event_id,
+						state,
+						result_status,
+						resume_status,
+						error,
+						attempts,
+						payload,
+						created_at
+					) VALUES (
+						:target_id,
+						NULLIF(:event_id, '')::uuid,
+						:state,
+						:result_status,
+						:resume_status,
+						:error,
+						:attempts,
+						:payload,
+						now()
+					) RETURNING id`
+
+	dto, err := deadLetterToDTO(dl)
+	if err != nil {
+		return "", err
+	}
+
+	var id string
+	rows, err := sqlx.NamedQueryContext(ctx, s.client(ctx), s.q(query), dto)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		if err := rows.Scan(&id); err != nil {
+			return "", err
+		}
+	} else {
+		return "", fmt.Errorf("no rows returned")
+	}
+
+	return id, nil
+}
+
+const deadLetterColumns = `
+					id,
+					target_id,
+					COALESCE(event_id::text, '') AS event_id,
+					state,
+					result_status,
+					resume_status,
+					error,
+					attempts,
+					payload,
+					created_at`
+
+func (s *stateRepo) getDeadLetterByID(ctx context.Context, id string) (DeadLetter, error) {
+	const query = `SELECT` + deadLetterColumns + ` FROM fsm_dead_letters WHERE id = $1`
+
+	var dto deadLetterDto
+	if err := s.client(ctx).GetContext(ctx, &dto, s.q(query), id); err != nil {
+		return DeadLetter{}, err
+	}
+
+	return dto.toDeadLetter()
+}
+
+func (s *stateRepo) getDeadLetters(ctx context.Context, filter DeadLetterFilter) (DeadLetterPage, error) {
+	w := &historyWhere{}
+	if filter.TargetID != "" {
+		w.add("target_id = ?", filter.TargetID)
+	}
+
+	if !filter.From.IsZero() {
+		w.add("created_at >= ?", filter.From)
+	}
+
+	if !filter.To.IsZero() {
+		w.add("created_at < ?", filter.To)
+	}
+
+	cursorTime, cursorID, err := decodeCursor(filter.Cursor)
+	if err != nil {
+		return DeadLetterPage{}, err
+	}
+
+	if cursorID != "" {
+		w.add("(created_at, id) < (?, ?)", cursorTime, cursorID)
+	}
+
+	query := `SELECT` + deadLetterColumns + `
+				FROM fsm_dead_letters
+				WHERE ` + w.String() + `
+				ORDER BY created_at DESC, id DESC
+				LIMIT ?`
+
+	var dtos []deadLetterDto
+	query = s.q(sqlx.Rebind(sqlx.DOLLAR, query))
+	if err = s.client(ctx).SelectContext(ctx, &dtos, query, w.withArgs(filter.Limit+1)...); err != nil {
+		return DeadLetterPage{}, err
+	}
+
+	var page DeadLetterPage
+	if len(dtos) > filter.Limit {
+		dtos = dtos[:filter.Limit]
+		last := dtos[len(dtos)-1]
+		page.NextCursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+
+	page.DeadLetters = make([]DeadLetter, 0, len(dtos))
+	for i := range dtos {
+		dl, err := dtos[i].toDeadLetter()
+		if err != nil {
+			return DeadLetterPage{}, err
+		}
+
+		page.DeadLetters = append(page.DeadLetters, dl)
+	}
+
+	return page, nil
+}
+
+func (s *stateRepo) deleteDeadLetter(ctx context.Context, id string) error {
+	const query = `DELETE FROM fsm_dead_letters WHERE id = $1`
+
+	_, err := s.client(ctx).ExecContext(ctx, s.q(query), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *stateRepo) getTargetState(ctx context.Context, targetID string) (TargetState, error) {
+	const query = `SELECT target_id, current_state, version, updated_at FROM fsm_targets WHERE target_id = $1`
+
+	var dto targetStateDto
+	err := s.client(ctx).GetContext(ctx, &dto, s.q(query), targetID)
+	if err != nil {
+		return TargetState{}, err
+	}
+
+	return dto.toTargetState(), nil
+}
+
+// swapTargetState saves the state of the target if its version is not changed,
+// the version 0 means the target is not stored yet
+func (s *stateRepo) swapTargetState(ctx context.Context, state TargetState) (int64, error) {
+	const (
+		insertQuery = `INSERT INTO fsm_targets (
+							target_id,
+							current_state,
+							version,
+							created_at,
+							updated_at
+						) VALUES (
+							:target_id,
+							:current_state,
+							1,
+							now(),
+							now()
+						) ON CONFLICT (target_id) DO NOTHING`
+
+		updateQuery = `UPDATE fsm_targets
+						SET current_state = :current_state,
+							version = version + 1,
+							updated_at = now()
+						WHERE target_id = :target_id AND version = :version`
+	)
+
+	query := updateQuery
+	if state.Version == 0 {
+		query = insertQuery
+	}
+
+	res, err := s.client(ctx).NamedExecContext(ctx, s.q(query), targetStateToDTO(state))
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	if n == 0 {
+		return 0, ErrConcurrentModification
+	}
+
+	return state.Version + 1, nil
+}
+
+var (
+	_ sqlClient = (*sqlx.DB)(nil)
+	_ sqlClient = (*sqlx.Tx)(nil)
+)
+
 // sqlClient - common interface for *sqlx.DB and *sqlx.TX
 // https://gist.github.com/hielfx/4469d35127d085fc3501d483e34d4bad
 //
@@ -246,6 +1096,7 @@ type sqlClient interface {
 	PrepareNamedContext(ctx context.Context, query string) (*sqlx.NamedStmt, error)
 	Preparex(query string) (*sqlx.Stmt, error)
 	PreparexContext(ctx context.Context, query string) (*sqlx.Stmt, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
 	QueryRowx(query string, args ...interface{}) *sqlx.Row
 	QueryRowxContext(ctx context.Context, query string, args ...interface{}) *sqlx.Row
 	Queryx(query string, args ...interface{}) (*sqlx.Rows, error)
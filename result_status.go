@@ -8,32 +8,26 @@ import (
 
 type ResultStatus string
 
-var _resultStatuses = map[string]ResultStatus{}
-
-// NewResultStatus creates a new ResultStatus instance
+// NewResultStatus creates a new ResultStatus instance in the default registry, see Registry
 func NewResultStatus(status string) ResultStatus {
-	if rs, ok := _resultStatuses[status]; ok {
-		return rs
-	}
-
-	rs := ResultStatus(status)
-	_resultStatuses[status] = rs
-
-	return rs
+	return defaultRegistry.NewResultStatus(status)
 }
 
 func (r *ResultStatus) String() string {
 	return string(*r)
 }
 
+// MarshalJSON checks the result status in the default registry,
+// use Registry.ParseResultStatus for the own registries
 func (r *ResultStatus) MarshalJSON() ([]byte, error) {
-	if _, ok := _resultStatuses[r.String()]; !ok {
+	if !defaultRegistry.hasResultStatus(r.String()) {
 		return nil, fmt.Errorf("result status %s not found", r.String())
 	}
 
 	return json.Marshal(r.String())
 }
 
+// UnmarshalJSON accepts the result statuses of the default registry only, see Registry
 func (r *ResultStatus) UnmarshalJSON(data []byte) error {
 	var status string
 	if err := json.Unmarshal(data, &status); err != nil {
@@ -44,22 +38,26 @@ func (r *ResultStatus) UnmarshalJSON(data []byte) error {
 		return fmt.Errorf("result status is empty")
 	}
 
-	if _, ok := _resultStatuses[status]; !ok {
-		return fmt.Errorf("result status %s not found", status)
+	rs, err := defaultRegistry.ParseResultStatus(status)
+	if err != nil {
+		return err
 	}
 
-	*r = _resultStatuses[status]
+	*r = rs
 
 	return nil
 }
 
+// Scan accepts the result statuses of the default registry only, see Registry
 func (r *ResultStatus) Scan(value interface{}) error {
 	if str, ok := value.(string); ok {
-		*r, ok = _resultStatuses[str]
-		if !ok {
-			return fmt.Errorf("result status %s not found", str)
+		rs, err := defaultRegistry.ParseResultStatus(str)
+		if err != nil {
+			return err
 		}
 
+		*r = rs
+
 		return nil
 	}
 
@@ -75,8 +73,16 @@ func (r *ResultStatus) Value() (driver.Value, error) {
 }
 
 var (
-	ResultStatusEmpty         = NewResultStatus("")
-	ResultStatusFail          = NewResultStatus("fail")
-	ResultStatusOk            = NewResultStatus("ok")
-	resultStatusWaitNextEvent = NewResultStatus("wait_next_event")
+	ResultStatusEmpty         = ResultStatus("")
+	ResultStatusFail          = ResultStatus("fail")
+	ResultStatusOk            = ResultStatus("ok")
+	resultStatusWaitNextEvent = ResultStatus("wait_next_event")
+
+	// ResultStatusAbandoned marks the logs of the executions abandoned mid-run, see Recovery
+	ResultStatusAbandoned = ResultStatus("abandoned")
 )
+
+// builtinResultStatuses are known to every registry
+var builtinResultStatuses = []ResultStatus{
+	ResultStatusEmpty, ResultStatusFail, ResultStatusOk, resultStatusWaitNextEvent, ResultStatusAbandoned,
+}
@@ -0,0 +1,94 @@
+package event_fsm
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	defaultRetryMultiplier = 2
+)
+
+// RetryPolicy is the policy of retrying the executor of the state when it returns an error
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of executions of the state, including the first one
+	MaxAttempts int
+
+	// InitialBackoff is the delay before the first retry
+	InitialBackoff time.Duration
+
+	// MaxBackoff limits the delay between retries, optional
+	MaxBackoff time.Duration
+
+	// Multiplier grows the delay after each retry, 2 by default
+	Multiplier float64
+
+	// Jitter is the fraction of the delay, from 0 to 1, which is randomly subtracted from it
+	Jitter float64
+
+	// Retryable reports whether the error is transient, optional.
+	// By default all errors are retried except the panics of the executor.
+	Retryable func(err error) bool
+
+	// ExhaustedStatus is the result status the state transitions with once the policy gives up, optional.
+	// If not set, ProcessEvent returns ExecutionError.
+	ExhaustedStatus ResultStatus
+}
+
+// SetRetryPolicy sets the retry policy of the state executor
+func (s *State[T]) SetRetryPolicy(policy RetryPolicy) {
+	s.Retry = &policy
+}
+
+// shouldRetry reports whether the execution failed with err on the attempt must be retried
+func (p *RetryPolicy) shouldRetry(attempt int, err error) bool {
+	if p == nil || attempt >= p.MaxAttempts {
+		return false
+	}
+
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+
+	var panicErr *PanicError
+	return !errors.As(err, &panicErr)
+}
+
+// backoff returns the delay after the failed attempt
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier <= 0 {
+		multiplier = defaultRetryMultiplier
+	}
+
+	d := float64(p.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 {
+		d = math.Min(d, float64(p.MaxBackoff))
+	}
+
+	if p.Jitter > 0 {
+		d -= d * math.Min(p.Jitter, 1) * rand.Float64()
+	}
+
+	return time.Duration(d)
+}
+
+// sleep waits for the duration or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,150 @@
+package event_fsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type stateFlaky struct {
+	failures int
+	calls    int
+}
+
+func (s *stateFlaky) Execute(ctx context.Context, data *eventData) (ResultStatus, error) {
+	s.calls++
+	if s.calls <= s.failures {
+		return ResultStatusFail, errors.New("temporary error")
+	}
+
+	return ResultStatusOk, nil
+}
+
+func TestRetryPolicy(t *testing.T) {
+	tests := []struct {
+		name      string
+		failures  int
+		policy    RetryPolicy
+		wantErr   bool
+		wantLogs  int
+		wantState StateName
+	}{
+		{
+			name:      "recovered",
+			failures:  2,
+			policy:    RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, Jitter: 0.5},
+			wantLogs:  3 + 1,
+			wantState: StatePrintResult,
+		},
+		{
+			name:     "exhausted",
+			failures: 5,
+			policy:   RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond},
+			wantErr:  true,
+			wantLogs: 2,
+		},
+		{
+			name:      "exhausted status",
+			failures:  5,
+			policy:    RetryPolicy{MaxAttempts: 2, ExhaustedStatus: WrongNumber},
+			wantLogs:  2 + 1 + 1,
+			wantState: StateLastCheck,
+		},
+		{
+			name:     "not retryable",
+			failures: 5,
+			policy:   RetryPolicy{MaxAttempts: 3, Retryable: func(err error) bool { return false }},
+			wantErr:  true,
+			wantLogs: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sd := NewStateDetector[*eventData]()
+			first := sd.NewState(StateFirstCheck, &stateFlaky{failures: tt.failures}, StateTypeTransition)
+			first.SetRetryPolicy(tt.policy)
+			printResult := sd.NewState(StatePrintResult, &stateFlaky{}, StateTypeTransition)
+			printResult.SetTerminal()
+			lastCheck := sd.NewState(StateLastCheck, &stateFlaky{}, StateTypeTransition)
+			lastCheck.SetTerminal()
+			first.SetNext(printResult, ResultStatusOk)
+			first.SetNext(lastCheck, WrongNumber)
+			sd.SetMainState(StateFirstCheck)
+
+			fsm, err := NewFSM(&Config[*eventData]{Logger: zap.NewNop(), StateDetector: sd, Store: NewMemoryStore()})
+			if err != nil {
+				t.Fatalf("NewFSM: %v", err)
+			}
+
+			ed := newEventData(1)
+			_, err = fsm.ProcessEvent(context.Background(), NewTarget(&ed))
+
+			var execErr *ExecutionError
+			if errors.As(err, &execErr) != tt.wantErr || (!tt.wantErr && err != nil) {
+				t.Fatalf("expected ExecutionError: %v, got %v", tt.wantErr, err)
+			}
+
+			if !tt.wantErr && ed.GetState() != tt.wantState {
+				t.Fatalf("expected state %s, got %s", tt.wantState, ed.GetState())
+			}
+
+			page, _ := fsm.History(context.Background(), ed.ID(), HistoryFilter{})
+			if len(page.Logs) != tt.wantLogs {
+				t.Fatalf("expected %d logs, got %d", tt.wantLogs, len(page.Logs))
+			}
+
+			// the logs are returned newest first
+			attempts, exhausted := 0, false
+			for i := len(page.Logs) - 1; i >= 0; i-- {
+				log := page.Logs[i]
+				if log.CurrentStateName != StateFirstCheck {
+					continue
+				}
+
+				if log.CurrentResultStatus == tt.policy.ExhaustedStatus {
+					// the outcome is recorded with the last attempt
+					if exhausted = true; log.Attempt != attempts {
+						t.Fatalf("expected the exhausted status with attempt %d, got %d", attempts, log.Attempt)
+					}
+
+					continue
+				}
+
+				attempts++
+				if log.Attempt != attempts {
+					t.Fatalf("expected attempt %d, got %d", attempts, log.Attempt)
+				}
+			}
+
+			if exhausted != (tt.policy.ExhaustedStatus != ResultStatusEmpty) {
+				t.Fatalf("expected the log of the exhausted status: %v", !exhausted)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := &RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 3}
+
+	for attempt, want := range map[int]time.Duration{
+		1: 100 * time.Millisecond,
+		2: 300 * time.Millisecond,
+		3: 900 * time.Millisecond,
+		4: time.Second,
+	} {
+		if got := p.backoff(attempt); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := p.backoff(2); got < 150*time.Millisecond || got > 300*time.Millisecond {
+			t.Fatalf("backoff with jitter out of range: %v", got)
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package event_fsm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultSchedulerPollInterval = time.Second
+	defaultSchedulerBatchSize    = 100
+	defaultSchedulerLease        = time.Minute
+	defaultSchedulerMaxAttempts  = 3
+)
+
+// ScheduledEvent is the event which resumes the target at the given time
+type ScheduledEvent struct {
+	ID       string
+	TargetID string
+
+	// Status is applied to the current state of the target, see FSM.ProcessEventWithStatus.
+	// The empty status runs the current state again.
+	Status ResultStatus
+
+	Payload EventPayload
+
+	// EventID is the event which scheduled the event, optional
+	EventID string
+
+	// State binds the event to the state of the target, optional.
+	// The bound event is dropped if the target is not in the state when it is delivered under the lock of the target,
+	// and is canceled when the state is finished by the next event of the target.
+	State StateName
+
+	// RunAt is the time the event is due
+	RunAt time.Time
+
+	// Attempts is the number of the deliveries of the event
+	Attempts int
+
+	CreatedAt time.Time
+
+	// payloadErr is the error of reading the stored payload, the event fails to deliver with it
+	payloadErr error
+}
+
+type scheduledEventDto struct {
+	ID           string          `db:"id"`
+	TargetID     string          `db:"target_id"`
+	ResultStatus string          `db:"result_status"`
+	Payload      json.RawMessage `db:"payload"`
+	EventID      string          `db:"event_id"`
+	State        string          `db:"state"`
+	RunAt        time.Time       `db:"run_at"`
+	Attempts     int             `db:"attempts"`
+	CreatedAt    time.Time       `db:"created_at"`
+}
+
+func (e *scheduledEventDto) toScheduledEvent() ScheduledEvent {
+	event := ScheduledEvent{
+		ID:        e.ID,
+		TargetID:  e.TargetID,
+		Status:    ResultStatus(e.ResultStatus),
+		EventID:   e.EventID,
+		State:     StateName(e.State),
+		RunAt:     e.RunAt,
+		Attempts:  e.Attempts,
+		CreatedAt: e.CreatedAt,
+	}
+
+	if len(e.Payload) > 0 {
+		if err := json.Unmarshal(e.Payload, &event.Payload); err != nil {
+			event.payloadErr = fmt.Errorf("json.Unmarshal of payload of scheduled event %s: %w", e.ID, err)
+		}
+	}
+
+	return event
+}
+
+func scheduledEventToDTO(e ScheduledEvent) (scheduledEventDto, error) {
+	dto := scheduledEventDto{
+		ID:           e.ID,
+		TargetID:     e.TargetID,
+		ResultStatus: e.Status.String(),
+		EventID:      e.EventID,
+		State:        e.State.String(),
+		RunAt:        e.RunAt,
+		Attempts:     e.Attempts,
+		CreatedAt:    e.CreatedAt,
+	}
+
+	if !e.Payload.IsEmpty() {
+		payload, err := json.Marshal(e.Payload)
+		if err != nil {
+			return scheduledEventDto{}, fmt.Errorf("json.Marshal of payload: %w", err)
+		}
+
+		dto.Payload = payload
+	}
+
+	return dto, nil
+}
+
+// SchedulerStore is implemented by the stores which can keep the scheduled events
+type SchedulerStore interface {
+	// SaveScheduledEvent saves the event and returns its ID
+	SaveScheduledEvent(ctx context.Context, event ScheduledEvent) (string, error)
+
+	// DeleteScheduledEvent deletes the event, the missing event is not an error
+	DeleteScheduledEvent(ctx context.Context, id string) error
+
+	// DeleteBoundScheduledEvents deletes the events of the target bound to the state
+	DeleteBoundScheduledEvents(ctx context.Context, targetID string, state StateName) error
+
+	// ClaimScheduledEvents returns up to limit due events, oldest first, increasing their attempts.
+	// The claimed events are not returned by the other calls until the lease expires,
+	// so the event which is not deleted is delivered again.
+	ClaimScheduledEvents(ctx context.Context, limit int, lease time.Duration) ([]ScheduledEvent, error)
+}
+
+// TargetLoader loads the data of the target by its ID
+type TargetLoader[T comparable] func(ctx context.Context, targetID string) (TargetData[T], error)
+
+type SchedulerConfig[T comparable] struct {
+	// FSM processes the due events, its Store must implement SchedulerStore
+	FSM *FSM[T]
+
+	// Load loads the target of the due event
+	Load TargetLoader[T]
+
+	// Logger is the logger of the FSM by default
+	Logger *zap.Logger
+
+	// PollInterval is the interval of polling the due events, 1 second by default
+	PollInterval time.Duration
+
+	// BatchSize is the maximum number of the events claimed by one poll, 100 by default
+	BatchSize int
+
+	// Lease is the time the claimed event is hidden from the other replicas, 1 minute by default.
+	// It must be longer than the processing of the event.
+	Lease time.Duration
+
+	// MaxAttempts is the number of the deliveries of the event before it is moved to the dead letters
+	// if Config.DeadLetters is set, 3 by default. Without the dead letters the event which failed to deliver
+	// is kept and delivered again after every lease. The failure of the state recorded by the FSM is not redelivered.
+	MaxAttempts int
+}
+
+// Scheduler delivers the scheduled events to the FSM when they are due.
+// Any number of replicas can run the scheduler over the same store, every event is claimed by one of them.
+// The event is delivered at least once: it is deleted only after it was processed or moved to the dead letters.
+// The scheduler waits for the busy target regardless of Config.LockMode.
+// In tenant mode the scheduler polls the tenant of the context passed to Run.
+type Scheduler[T comparable] struct {
+	l *zap.Logger
+
+	fsm   *FSM[T]
+	store SchedulerStore
+	load  TargetLoader[T]
+
+	pollInterval time.Duration
+	batchSize    int
+	lease        time.Duration
+	maxAttempts  int
+}
+
+func NewScheduler[T comparable](cfg SchedulerConfig[T]) (*Scheduler[T], error) {
+	if cfg.FSM == nil {
+		return nil, errors.New("FSM is required")
+	}
+
+	if cfg.Load == nil {
+		return nil, errors.New("Load is required")
+	}
+
+	store, ok := cfg.FSM.store.(SchedulerStore)
+	if !ok {
+		return nil, fmt.Errorf("scheduler: %w", ErrNotSupported)
+	}
+
+	s := &Scheduler[T]{
+		l:     cfg.Logger,
+		fsm:   cfg.FSM,
+		store: store,
+		load:  cfg.Load,
+
+		pollInterval: cfg.PollInterval,
+		batchSize:    cfg.BatchSize,
+		lease:        cfg.Lease,
+		maxAttempts:  cfg.MaxAttempts,
+	}
+
+	if s.l == nil {
+		s.l = cfg.FSM.l
+	}
+
+	if s.pollInterval <= 0 {
+		s.pollInterval = defaultSchedulerPollInterval
+	}
+
+	if s.batchSize <= 0 {
+		s.batchSize = defaultSchedulerBatchSize
+	}
+
+	if s.lease <= 0 {
+		s.lease = defaultSchedulerLease
+	}
+
+	if s.maxAttempts <= 0 {
+		s.maxAttempts = defaultSchedulerMaxAttempts
+	}
+
+	return s, nil
+}
+
+// Schedule saves the event to be delivered at event.RunAt and returns its ID
+func (s *Scheduler[T]) Schedule(ctx context.Context, event ScheduledEvent) (string, error) {
+	if event.TargetID == "" {
+		return "", errors.New("target id is required")
+	}
+
+	if _, err := s.fsm.stateDetector.registry.ParseResultStatus(event.Status.String()); err != nil {
+		return "", err
+	}
+
+	id, err := s.store.SaveScheduledEvent(ctx, event)
+	if err != nil {
+		return "", fmt.Errorf("s.store.SaveScheduledEvent: %w", err)
+	}
+
+	return id, nil
+}
+
+// Cancel deletes the scheduled event which is not delivered yet
+func (s *Scheduler[T]) Cancel(ctx context.Context, id string) error {
+	if err := s.store.DeleteScheduledEvent(ctx, id); err != nil {
+		return fmt.Errorf("s.store.DeleteScheduledEvent: %w", err)
+	}
+
+	return nil
+}
+
+// Run polls and delivers the due events until ctx is done
+func (s *Scheduler[T]) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		// the full batch means there may be more due events
+		for {
+			n, err := s.Poll(ctx)
+			if err != nil {
+				s.l.Error("scheduler poll", zap.Error(err))
+			}
+
+			if err != nil || n < s.batchSize || ctx.Err() != nil {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll claims one batch of the due events and delivers them, it returns the number of the claimed events
+func (s *Scheduler[T]) Poll(ctx context.Context) (int, error) {
+	events, err := s.store.ClaimScheduledEvents(ctx, s.batchSize, s.lease)
+	if err != nil {
+		return 0, fmt.Errorf("s.store.ClaimScheduledEvents: %w", err)
+	}
+
+	for _, e := range events {
+		if err = s.deliver(ctx, e); err != nil {
+			s.l.Error(
+				"error delivering scheduled event", zap.Error(err),
+				zap.String("id", e.ID), zap.String("target_id", e.TargetID), zap.Int("attempt", e.Attempts),
+			)
+
+			if e.Attempts < s.maxAttempts || !s.saveDeadLetter(ctx, e, err) {
+				// the event is delivered again when the lease expires
+				continue
+			}
+		}
+
+		if err = s.store.DeleteScheduledEvent(ctx, e.ID); err != nil {
+			s.l.Error("s.store.DeleteScheduledEvent", zap.Error(err), zap.String("id", e.ID))
+		}
+	}
+
+	return len(events), nil
+}
+
+// deliver processes the event, the failure of the state is recorded by the FSM and is not redelivered
+func (s *Scheduler[T]) deliver(ctx context.Context, e ScheduledEvent) error {
+	if e.payloadErr != nil {
+		return e.payloadErr
+	}
+
+	data, err := s.load(ctx, e.TargetID)
+	if err != nil {
+		return fmt.Errorf("s.load: %w", err)
+	}
+
+	if data == nil || data.IsNull() {
+		return fmt.Errorf("target %s is nil", e.TargetID)
+	}
+
+	t := NewTarget(data)
+	t.payload, t.resumeStatus, t.boundState = e.Payload, e.Status, e.State
+
+	// the busy target doesn't use up the attempts of the event
+	if _, err = s.fsm.lockAndProcess(ctx, t, true); err != nil && !isDeadLetter(err) {
+		return err
+	}
+
+	return nil
+}
+
+// saveDeadLetter moves the event which failed to deliver to the dead letters,
+// it returns false if the event is kept: the dead letters are not recorded or failed to save
+func (s *Scheduler[T]) saveDeadLetter(ctx context.Context, e ScheduledEvent, err error) bool {
+	if s.fsm.deadLetters == nil {
+		return false
+	}
+
+	dl := DeadLetter{
+		TargetID:     e.TargetID,
+		EventID:      e.EventID,
+		State:        e.State,
+		ResumeStatus: e.Status,
+		Error:        err.Error(),
+		Attempts:     e.Attempts,
+		Payload:      e.Payload,
+	}
+
+	if _, err = s.fsm.deadLetters.SaveDeadLetter(ctx, dl); err != nil {
+		s.l.Error("s.fsm.deadLetters.SaveDeadLetter", zap.Error(err), zap.String("id", e.ID))
+		return false
+	}
+
+	return true
+}
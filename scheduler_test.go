@@ -0,0 +1,257 @@
+package event_fsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestScheduler(t *testing.T) {
+	ctx := context.Background()
+
+	sd := NewStateDetector[*eventData]()
+	first := sd.NewState(StateFirstCheck, &stateFlaky{}, StateTypeTransition)
+	wait := sd.NewState(StateManualAdd, &stateFlaky{}, StateTypeWaitEvent)
+	printResult := sd.NewState(StatePrintResult, &stateFlaky{}, StateTypeTransition)
+	printResult.SetTerminal()
+	first.SetNext(wait, ResultStatusOk)
+	wait.SetNext(printResult, WrongNumber)
+	sd.SetMainState(StateFirstCheck)
+
+	fsm, err := NewFSM(&Config[*eventData]{Logger: zap.NewNop(), StateDetector: sd, Store: NewMemoryStore()})
+	if err != nil {
+		t.Fatalf("NewFSM: %v", err)
+	}
+
+	ed := newEventData(1)
+	if _, err = fsm.ProcessEvent(ctx, NewTarget(&ed)); err != nil {
+		t.Fatalf("ProcessEvent: %v", err)
+	}
+
+	scheduler, err := NewScheduler(SchedulerConfig[*eventData]{
+		FSM: fsm,
+		Load: func(ctx context.Context, targetID string) (TargetData[*eventData], error) {
+			return &ed, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewScheduler: %v", err)
+	}
+
+	if _, err = scheduler.Schedule(ctx, ScheduledEvent{
+		TargetID: ed.ID(), Status: WrongNumber, RunAt: time.Now().Add(time.Hour),
+	}); err != nil {
+		t.Fatalf("Schedule: %v", err)
+	}
+
+	if n, err := scheduler.Poll(ctx); err != nil || n != 0 {
+		t.Fatalf("expected no due events, got %d, %v", n, err)
+	}
+
+	if _, err = scheduler.Schedule(ctx, ScheduledEvent{TargetID: ed.ID(), Status: WrongNumber}); err != nil {
+		t.Fatalf("Schedule: %v", err)
+	}
+
+	if n, err := scheduler.Poll(ctx); err != nil || n != 1 {
+		t.Fatalf("expected 1 due event, got %d, %v", n, err)
+	}
+
+	if ed.GetState() != StatePrintResult {
+		t.Fatalf("expected state %s, got %s", StatePrintResult, ed.GetState())
+	}
+
+	if n, err := scheduler.Poll(ctx); err != nil || n != 0 {
+		t.Fatalf("expected the delivered event to be deleted, got %d, %v", n, err)
+	}
+}
+
+func TestSchedulerFailedDelivery(t *testing.T) {
+	ctx := context.Background()
+
+	sd := NewStateDetector[*eventData]()
+	first := sd.NewState(StateFirstCheck, &stateFlaky{}, StateTypeTransition)
+	wait := sd.NewState(StateManualAdd, &stateFlaky{}, StateTypeWaitEvent)
+	printResult := sd.NewState(StatePrintResult, &stateFlaky{}, StateTypeTransition)
+	printResult.SetTerminal()
+	first.SetNext(wait, ResultStatusOk)
+	wait.SetNext(printResult, WrongNumber)
+	sd.SetMainState(StateFirstCheck)
+
+	newScheduler := func(t *testing.T, deadLetters bool) (*FSM[*eventData], *Scheduler[*eventData], *eventData) {
+		fsm, err := NewFSM(&Config[*eventData]{
+			Logger: zap.NewNop(), StateDetector: sd, Store: NewMemoryStore(), DeadLetters: deadLetters,
+		})
+		if err != nil {
+			t.Fatalf("NewFSM: %v", err)
+		}
+
+		ed := newEventData(1)
+		if _, err = fsm.ProcessEvent(ctx, NewTarget(&ed)); err != nil {
+			t.Fatalf("ProcessEvent: %v", err)
+		}
+
+		scheduler, err := NewScheduler(SchedulerConfig[*eventData]{
+			FSM: fsm,
+			Load: func(ctx context.Context, targetID string) (TargetData[*eventData], error) {
+				if targetID != ed.ID() {
+					return nil, errors.New("target not found")
+				}
+
+				return &ed, nil
+			},
+			MaxAttempts: 2,
+			Lease:       time.Millisecond,
+		})
+		if err != nil {
+			t.Fatalf("NewScheduler: %v", err)
+		}
+
+		return fsm, scheduler, &ed
+	}
+
+	t.Run("recorded failure", func(t *testing.T) {
+		_, scheduler, ed := newScheduler(t, false)
+
+		// the wait state has no transition for the status, the failure is recorded by the FSM
+		if _, err := scheduler.Schedule(ctx, ScheduledEvent{TargetID: ed.ID(), Status: ResultStatusOk}); err != nil {
+			t.Fatalf("Schedule: %v", err)
+		}
+
+		if n, err := scheduler.Poll(ctx); err != nil || n != 1 {
+			t.Fatalf("expected 1 due event, got %d, %v", n, err)
+		}
+
+		time.Sleep(time.Millisecond * 5)
+		if n, err := scheduler.Poll(ctx); err != nil || n != 0 {
+			t.Fatalf("expected the recorded failure not to be redelivered, got %d, %v", n, err)
+		}
+	})
+
+	t.Run("corrupt payload", func(t *testing.T) {
+		_, scheduler, ed := newScheduler(t, false)
+
+		dto := scheduledEventDto{ID: "1", TargetID: ed.ID(), ResultStatus: WrongNumber.String(), Payload: []byte(`{"name":`)}
+		if err := scheduler.deliver(ctx, dto.toScheduledEvent()); err == nil {
+			t.Fatal("expected the error of the corrupt payload")
+		}
+
+		if ed.GetState() != StateManualAdd {
+			t.Fatalf("expected the event not delivered, got state %s", ed.GetState())
+		}
+	})
+
+	for _, deadLetters := range []bool{false, true} {
+		t.Run(fmt.Sprintf("dead letters %t", deadLetters), func(t *testing.T) {
+			fsm, scheduler, _ := newScheduler(t, deadLetters)
+
+			if _, err := scheduler.Schedule(ctx, ScheduledEvent{TargetID: "missing", Status: WrongNumber}); err != nil {
+				t.Fatalf("Schedule: %v", err)
+			}
+
+			for i := 0; i < 2; i++ {
+				if n, err := scheduler.Poll(ctx); err != nil || n != 1 {
+					t.Fatalf("attempt %d: expected 1 due event, got %d, %v", i+1, n, err)
+				}
+
+				time.Sleep(time.Millisecond * 5)
+			}
+
+			n, err := scheduler.Poll(ctx)
+			if err != nil {
+				t.Fatalf("Poll: %v", err)
+			}
+
+			if !deadLetters {
+				if n != 1 {
+					t.Fatalf("expected the failed event to be kept, got %d", n)
+				}
+
+				return
+			}
+
+			if n != 0 {
+				t.Fatalf("expected the failed event to be moved to the dead letters, got %d", n)
+			}
+
+			page, err := fsm.DeadLetters(ctx, DeadLetterFilter{TargetID: "missing"})
+			if err != nil || len(page.DeadLetters) != 1 {
+				t.Fatalf("expected 1 dead letter, got %+v, %v", page.DeadLetters, err)
+			}
+
+			if dl := page.DeadLetters[0]; dl.ResumeStatus != WrongNumber || dl.Attempts != 2 {
+				t.Fatalf("unexpected dead letter %+v", dl)
+			}
+		})
+	}
+}
+
+func TestSchedulerBoundStateUnderLock(t *testing.T) {
+	ctx := context.Background()
+	blocking := &stateBlocking{started: make(chan struct{}, 1), release: make(chan struct{})}
+
+	sd := NewStateDetector[*eventData]()
+	first := sd.NewState(StateFirstCheck, &stateFlaky{}, StateTypeTransition)
+	wait := sd.NewState(StateManualAdd, blocking, StateTypeWaitEvent)
+	printResult := sd.NewState(StatePrintResult, &stateFlaky{}, StateTypeTransition)
+	printResult.SetTerminal()
+	first.SetNext(wait, ResultStatusOk)
+	wait.SetNext(printResult, ResultStatusOk)
+	sd.SetMainState(StateFirstCheck)
+
+	fsm, err := NewFSM(&Config[*eventData]{Logger: zap.NewNop(), StateDetector: sd, Store: NewMemoryStore()})
+	if err != nil {
+		t.Fatalf("NewFSM: %v", err)
+	}
+
+	ed := newEventData(1)
+	if _, err = fsm.ProcessEvent(ctx, NewTarget(&ed)); err != nil {
+		t.Fatalf("ProcessEvent: %v", err)
+	}
+
+	scheduler, err := NewScheduler(SchedulerConfig[*eventData]{
+		FSM: fsm,
+		Load: func(ctx context.Context, targetID string) (TargetData[*eventData], error) {
+			return &ed, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewScheduler: %v", err)
+	}
+
+	// the next event holds the lock of the target in the state the scheduled event is bound to
+	done := make(chan error)
+	go func() {
+		_, err := fsm.ProcessEvent(ctx, NewTarget(&ed))
+		done <- err
+	}()
+	<-blocking.started
+
+	polled := make(chan error)
+	go func() {
+		polled <- scheduler.deliver(ctx, ScheduledEvent{TargetID: ed.ID(), Status: ResultStatusOk, State: StateManualAdd})
+	}()
+
+	time.Sleep(time.Millisecond * 20)
+	close(blocking.release)
+
+	if err = <-done; err != nil {
+		t.Fatalf("ProcessEvent: %v", err)
+	}
+
+	if err = <-polled; err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+
+	page, err := fsm.Events(ctx, ed.ID(), EventsFilter{})
+	if err != nil {
+		t.Fatalf("Events: %v", err)
+	}
+
+	if len(page.Events) != 2 {
+		t.Fatalf("expected the event bound to the left state to be dropped, got %d events", len(page.Events))
+	}
+}
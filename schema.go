@@ -0,0 +1,113 @@
+package event_fsm
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	defaultSchemaName = "fsm"
+)
+
+// tableNameRe matches the beginning of the FSM table names in the queries and the migrations
+var tableNameRe = regexp.MustCompile(`\bfsm_`)
+
+// Schema is the location of the FSM tables in the database
+type Schema struct {
+	// Name is the Postgres schema of the tables, the current schema of the connection if empty
+	Name string
+
+	// TablePrefix is prepended to the names of the tables and their indexes, optional
+	TablePrefix string
+}
+
+// forTenant returns the schema of the tenant, it is named after the base schema and the tenant ID
+func (s Schema) forTenant(tenantID string) Schema {
+	name := s.Name
+	if name == "" {
+		name = defaultSchemaName
+	}
+
+	return Schema{
+		Name:        name + "_" + tenantID,
+		TablePrefix: s.TablePrefix,
+	}
+}
+
+// qualify rewrites the table names of the query, so it addresses the tables of the schema
+func (s Schema) qualify(query string) string {
+	qualifier := s.TablePrefix + "fsm_"
+	if s.Name != "" {
+		qualifier = pgx.Identifier{s.Name}.Sanitize() + "." + qualifier
+	}
+
+	return tableNameRe.ReplaceAllLiteralString(query, qualifier)
+}
+
+// prefix rewrites the table and index names of the migration, the schema is chosen by search_path
+func (s Schema) prefix(query string) string {
+	if s.TablePrefix == "" {
+		return query
+	}
+
+	return tableNameRe.ReplaceAllLiteralString(query, s.TablePrefix+"fsm_")
+}
+
+type tenantKey struct{}
+
+// WithTenant returns the context of the tenant, required by ProcessEvent and the queries
+// of FSM working in tenant mode, see Config.TenantMode
+func WithTenant(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenantID)
+}
+
+// TenantFromContext returns the tenant set with WithTenant
+func TenantFromContext(ctx context.Context) (string, bool) {
+	tenantID, ok := ctx.Value(tenantKey{}).(string)
+	return tenantID, ok && tenantID != ""
+}
+
+// tenantRepos keeps the repositories of the tenants,
+// the schema of the tenant is created and migrated on first use
+type tenantRepos struct {
+	mu sync.Mutex
+
+	db     *dbStore
+	schema Schema
+	repos  map[string]*stateRepo
+}
+
+func newTenantRepos(db *dbStore, schema Schema) *tenantRepos {
+	return &tenantRepos{
+		db:     db,
+		schema: schema,
+		repos:  make(map[string]*stateRepo),
+	}
+}
+
+func (t *tenantRepos) get(ctx context.Context, tenantID string) (*stateRepo, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if repo, ok := t.repos[tenantID]; ok {
+		return repo, nil
+	}
+
+	schema := t.schema.forTenant(tenantID)
+	if err := createSchema(ctx, t.db.db, schema.Name); err != nil {
+		return nil, fmt.Errorf("createSchema %s failed: %w", schema.Name, err)
+	}
+
+	if err := MigrateUp(t.db.db, schema); err != nil {
+		return nil, fmt.Errorf("MigrateUp %s failed: %w", schema.Name, err)
+	}
+
+	repo := newRepo(t.db, schema)
+	t.repos[tenantID] = repo
+
+	return repo, nil
+}
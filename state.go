@@ -11,6 +11,11 @@ const (
 	StateTypeWaitEvent
 )
 
+// Executor runs the state for the target and returns the result status choosing the next state.
+//
+// Execute may be called more than once for the same target, state and event:
+// by the retry policy of the state and by Recovery after the process died mid-run.
+// The executors with side effects must be idempotent, e.g. deduplicate them by the event ID, see Execution.
 type Executor[T comparable] interface {
 	Execute(ctx context.Context, e T) (ResultStatus, error)
 }
@@ -23,6 +28,20 @@ type State[T comparable] struct {
 	Next map[string]*State[T]
 
 	Executor Executor[T]
+
+	// Retry is the retry policy of the executor, optional
+	Retry *RetryPolicy
+
+	// Timeout is the timeout of the state waiting for the next event, optional
+	Timeout *StateTimeout
+
+	// Terminal marks the state as the end of the flow, it is allowed to have no next states
+	Terminal bool
+}
+
+// SetTerminal marks the state as the end of the flow
+func (s *State[T]) SetTerminal() {
+	s.Terminal = true
 }
 
 func (s *State[T]) SetNext(nextState *State[T], response ResultStatus) {
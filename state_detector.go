@@ -4,16 +4,34 @@ package event_fsm
 type StateDetector[T comparable] struct {
 	states        map[string]*State[T]
 	mainStateName StateName
+
+	registry *Registry
+
+	// validationWarnings are the checks which don't fail NewFSM, see Definition.ValidationWarnings
+	validationWarnings []ValidationCheck
 }
 
+// NewStateDetector creates the state detector with the default registry,
+// its states are named with the package-level NewStateName
 func NewStateDetector[T comparable]() *StateDetector[T] {
+	return NewStateDetectorWithRegistry[T](defaultRegistry)
+}
+
+// NewStateDetectorWithRegistry creates the state detector which knows only the state names of the registry
+func NewStateDetectorWithRegistry[T comparable](registry *Registry) *StateDetector[T] {
 	return &StateDetector[T]{
-		states: make(map[string]*State[T]),
+		states:   make(map[string]*State[T]),
+		registry: registry,
 	}
 }
 
+// Registry returns the registry of the state names and the result statuses of the state detector
+func (sd *StateDetector[T]) Registry() *Registry {
+	return sd.registry
+}
+
 func (sd *StateDetector[T]) NewState(name StateName, executor Executor[T], stateType StateType) *State[T] {
-	if _, ok := _stateNames[name.String()]; !ok {
+	if !sd.registry.hasStateName(name.String()) {
 		panic(ErrStateNameNotFound)
 	}
 
@@ -28,6 +46,11 @@ func (sd *StateDetector[T]) NewState(name StateName, executor Executor[T], state
 	return state
 }
 
+// NewContextState creates the state whose executor receives the whole execution, including the event payload
+func (sd *StateDetector[T]) NewContextState(name StateName, executor ContextExecutor[T], stateType StateType) *State[T] {
+	return sd.NewState(name, contextExecutor[T]{executor: executor}, stateType)
+}
+
 func (sd *StateDetector[T]) SetMainState(state StateName) {
 	sd.mainStateName = state
 }
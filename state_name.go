@@ -8,53 +8,34 @@ import (
 
 type StateName string
 
-var _stateNames = map[string]StateName{}
-
-func checkStateName(sn StateName) (bool, error) {
-	if sn.String() == "" {
-		return false, ErrEmptyStateName
-	}
-	_, ok := _stateNames[sn.String()]
-	if !ok {
-		return false, ErrStateNameNotFound
-	}
-
-	return true, nil
-}
-
-// State result statuses
+// NewStateName registers the state name in the default registry, see Registry
 func NewStateName(name string) StateName {
-	if sn, ok := _stateNames[name]; ok {
-		return sn
-	}
-
-	sn := StateName(name)
-	_stateNames[name] = sn
-
-	return sn
+	return defaultRegistry.NewStateName(name)
 }
 
 func (sn *StateName) String() string {
 	return string(*sn)
 }
 
-// MarshalJSON check if state name is valid or not before marshalling with Alias
+// MarshalJSON check if state name is valid or not before marshalling with Alias.
+// The state name is checked in the default registry, use Registry.ParseStateName for the own registries.
 func (sn *StateName) MarshalJSON() ([]byte, error) {
-	if _, ok := _stateNames[sn.String()]; !ok {
+	if !defaultRegistry.hasStateName(sn.String()) {
 		return nil, fmt.Errorf("state name %s not found", sn.String())
 	}
 
 	return json.Marshal(sn.String())
 }
 
-// UnmarshalJSON unmarshal eventData to string, then check if it is a valid state name or not
+// UnmarshalJSON unmarshal eventData to string, then check if it is a valid state name or not.
+// Only the state names of the default registry are valid, see Registry.
 func (sn *StateName) UnmarshalJSON(data []byte) error {
 	var name string
 	if err := json.Unmarshal(data, &name); err != nil {
 		return err
 	}
 
-	if _, ok := _stateNames[name]; !ok {
+	if !defaultRegistry.hasStateName(name) {
 		return fmt.Errorf("state name %s not found", name)
 	}
 
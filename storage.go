@@ -13,104 +13,214 @@ import (
 )
 
 const (
-	eventKeyPrefix = "fsm:event:"
-	logKeyPrefix   = "fsm:log:"
-	cacheTTL       = time.Minute * 15
+	eventKeyPrefix  = "fsm:event:"
+	logKeyPrefix    = "fsm:log:"
+	targetKeyPrefix = "fsm:target:"
+	cacheTTL        = time.Minute * 15
 )
 
+// Store is the persistence backend of the FSM.
+// It keeps the events of the targets and the logs of the states they passed through.
+//
+// The FSM writes the events, the logs and the target states holding the lock of the target,
+// its fencing token is in the context of the writes, see LockToken. The store should reject
+// the write of the target with ErrLockLost if a greater token of the target has been written,
+// so the processing which lost its lock, e.g. paused longer than the lease, can't overwrite the new one.
+type Store interface {
+	// SaveEvent saves a new event and returns its ID
+	SaveEvent(ctx context.Context, event Event) (string, error)
+
+	// UpdateEvent updates the last result status of the event
+	UpdateEvent(ctx context.Context, event Event) error
+
+	// GetEvent returns the event by its ID
+	GetEvent(ctx context.Context, id string) (Event, error)
+
+	// SaveLog saves a new log without result status and returns its ID
+	SaveLog(ctx context.Context, log Log) (string, error)
+
+	// UpdateLog sets the result status of the log
+	UpdateLog(ctx context.Context, log Log) error
+
+	// CreateFullLog saves a new log with result status and returns its ID
+	CreateFullLog(ctx context.Context, log Log) (string, error)
+
+	// GetEvents returns a page of the target events, newest first
+	GetEvents(ctx context.Context, targetID string, filter EventsFilter) (EventPage, error)
+
+	// GetLogs returns a page of the target logs, newest first
+	GetLogs(ctx context.Context, targetID string, filter HistoryFilter) (LogPage, error)
+
+	// DeleteLogs deletes logs older than the specified duration,
+	// keeping the last 'keepCount' of them for each target
+	DeleteLogs(ctx context.Context, olderThan time.Duration, keepCount int) error
+}
+
+var (
+	_ Store          = (*storage)(nil)
+	_ SchedulerStore = (*storage)(nil)
+	_ RecoveryStore  = (*storage)(nil)
+
+	_ IdempotencyStore = (*storage)(nil)
+	_ TargetStore      = (*storage)(nil)
+	_ TxStore          = (*storage)(nil)
+	_ OutboxStore      = (*storage)(nil)
+	_ QueueStore       = (*storage)(nil)
+	_ DeadLetterStore  = (*storage)(nil)
+)
+
+// storage is the Store backed by Postgres with Redis cache in front of it
 type storage struct {
 	l *zap.Logger
 
 	appLabel string
 
-	db    *stateRepo
-	cache *rClient
+	db      *stateRepo
+	tenants *tenantRepos
+	cache   *rClient
 }
 
-func newStorage(l *zap.Logger, appLabel string, db *dbStore, cache *rClient) *storage {
-	return &storage{
+func newStorage(l *zap.Logger, appLabel string, db *dbStore, cache *rClient, schema Schema, tenantMode bool) *storage {
+	s := &storage{
 		l:        l,
 		appLabel: appLabel,
 
-		db:    newRepo(db),
 		cache: cache,
 	}
+
+	if tenantMode {
+		s.tenants = newTenantRepos(db, schema)
+	} else {
+		s.db = newRepo(db, schema)
+	}
+
+	return s
 }
 
-func (s *storage) makeKey(keyPrefix, id string) string {
+// repo returns the repository of the tenant from the context in tenant mode, the only repository otherwise
+func (s *storage) repo(ctx context.Context) (*stateRepo, error) {
+	if s.tenants == nil {
+		return s.db, nil
+	}
+
+	tenantID, ok := TenantFromContext(ctx)
+	if !ok {
+		return nil, ErrTenantNotSet
+	}
+
+	return s.tenants.get(ctx, tenantID)
+}
+
+func (s *storage) makeKey(ctx context.Context, keyPrefix, id string) string {
+	tenantID, _ := TenantFromContext(ctx)
+
 	b := strings.Builder{}
-	b.Grow(len(keyPrefix) + len(s.appLabel) + len(id) + 2)
+	b.Grow(len(keyPrefix) + len(s.appLabel) + len(tenantID) + len(id) + 3)
 	b.WriteString(keyPrefix)
 	b.WriteString(s.appLabel)
 	b.WriteByte(':')
+	if tenantID != "" {
+		b.WriteString(tenantID)
+		b.WriteByte(':')
+	}
 	b.WriteString(id)
 	return b.String()
 }
 
-func (s *storage) saveLog(ctx context.Context, log Log) (string, error) {
+// setCache saves the value to cache when the transaction of the context is committed
+func (s *storage) setCache(ctx context.Context, op, key string, value any) {
+	afterCommit(ctx, func() {
+		if err := s.cache.Set(context.WithoutCancel(ctx), key, value, cacheTTL); err != nil {
+			s.l.Error(op+".cache.Set", zap.String("key", key), zap.Error(err))
+		}
+	})
+}
+
+func (s *storage) SaveLog(ctx context.Context, log Log) (string, error) {
+	db, err := s.repo(ctx)
+	if err != nil {
+		return "", err
+	}
+
 	// Save the log to the database
-	id, err := s.db.createLog(ctx, log)
+	var id string
+	err = db.fenced(ctx, log.TargetID, func(ctx context.Context) (err error) {
+		id, err = db.createLog(ctx, log)
+		return err
+	})
 	if err != nil {
 		return "", fmt.Errorf("db.createLog: %w", err)
 	}
 
 	// Save the log to cache
-	if err := s.cache.Set(ctx, s.makeKey(logKeyPrefix, log.TargetID), logToDTO(log), cacheTTL); err != nil {
-		s.l.Error(
-			"createLog.cache.Set", zap.String("key", s.makeKey(logKeyPrefix, log.TargetID)), zap.Error(err),
-		)
-	}
+	s.setCache(ctx, "SaveLog", s.makeKey(ctx, logKeyPrefix, log.TargetID), logToDTO(log))
 
 	return id, nil
 }
 
-func (s *storage) createFullLog(ctx context.Context, log Log) (string, error) {
+func (s *storage) CreateFullLog(ctx context.Context, log Log) (string, error) {
+	db, err := s.repo(ctx)
+	if err != nil {
+		return "", err
+	}
+
 	// Save the log to the database
-	id, err := s.db.createFullLog(ctx, log)
+	var id string
+	err = db.fenced(ctx, log.TargetID, func(ctx context.Context) (err error) {
+		id, err = db.createFullLog(ctx, log)
+		return err
+	})
 	if err != nil {
 		return "", fmt.Errorf("db.createFullLog: %w", err)
 	}
 
 	// Save the log to cache
-	if err := s.cache.Set(ctx, s.makeKey(logKeyPrefix, log.TargetID), logToDTO(log), cacheTTL); err != nil {
-		s.l.Error(
-			"createFullLog.cache.Set", zap.String("key", s.makeKey(logKeyPrefix, log.TargetID)), zap.Error(err),
-		)
-	}
+	s.setCache(ctx, "CreateFullLog", s.makeKey(ctx, logKeyPrefix, log.TargetID), logToDTO(log))
 
 	return id, nil
 }
 
-func (s *storage) updateLog(ctx context.Context, log Log) error {
+func (s *storage) UpdateLog(ctx context.Context, log Log) error {
+	db, err := s.repo(ctx)
+	if err != nil {
+		return err
+	}
+
 	// Update the log in the database
-	if err := s.db.updateLog(ctx, log); err != nil {
+	err = db.fenced(ctx, log.TargetID, func(ctx context.Context) error {
+		return db.updateLog(ctx, log)
+	})
+	if err != nil {
 		return fmt.Errorf("db.updateLog: %w", err)
 	}
 
 	// Update the log in cache
-	if err := s.cache.Set(ctx, s.makeKey(logKeyPrefix, log.TargetID), logToDTO(log), cacheTTL); err != nil {
-		s.l.Error(
-			"updateLog.cache.Set", zap.String("key", s.makeKey(logKeyPrefix, log.TargetID)), zap.Error(err),
-		)
-	}
+	s.setCache(ctx, "UpdateLog", s.makeKey(ctx, logKeyPrefix, log.TargetID), logToDTO(log))
 
 	return nil
 }
 
-func (s *storage) getEvent(ctx context.Context, id string) (Event, error) {
+func (s *storage) GetEvent(ctx context.Context, id string) (Event, error) {
 	// Check the cache first
 	var eventDTO eventDto
-	if err := s.cache.Get(ctx, s.makeKey(eventKeyPrefix, id), &eventDTO); err != nil {
+	if err := s.cache.Get(ctx, s.makeKey(ctx, eventKeyPrefix, id), &eventDTO); err != nil {
 		if !errors.Is(err, redis.Nil) {
 			s.l.Error(
-				"getEvent.s.cache.Get", zap.String("key", s.makeKey(eventKeyPrefix, id)), zap.Error(err),
+				"GetEvent.s.cache.Get", zap.String("key", s.makeKey(ctx, eventKeyPrefix, id)), zap.Error(err),
 			)
 		}
+	} else if event, err := eventDTO.toEvent(); err != nil {
+		s.l.Error("GetEvent.eventDTO.toEvent", zap.String("key", s.makeKey(ctx, eventKeyPrefix, id)), zap.Error(err))
 	} else {
-		return eventDTO.toEvent(), nil
+		return event, nil
+	}
+
+	db, err := s.repo(ctx)
+	if err != nil {
+		return Event{}, err
 	}
 
-	event, err := s.db.getEventByID(ctx, id)
+	event, err := db.getEventByID(ctx, id)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Event{}, ErrLastLogNotFound
@@ -120,42 +230,438 @@ func (s *storage) getEvent(ctx context.Context, id string) (Event, error) {
 	}
 
 	// Save the event to cache
-	if err := s.cache.Set(ctx, s.makeKey(eventKeyPrefix, id), eventToDTO(event), cacheTTL); err != nil {
-		s.l.Error("getEvent.cache.Set", zap.String("key", s.makeKey(eventKeyPrefix, id)), zap.Error(err))
+	if dto, err := eventToDTO(event); err == nil {
+		s.setCache(ctx, "GetEvent", s.makeKey(ctx, eventKeyPrefix, id), dto)
 	}
 
 	return event, nil
 }
 
-func (s *storage) saveEvent(ctx context.Context, event Event) (string, error) {
+func (s *storage) SaveEvent(ctx context.Context, event Event) (string, error) {
+	db, err := s.repo(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	dto, err := eventToDTO(event)
+	if err != nil {
+		return "", err
+	}
+
 	// Save the event to the database
-	id, err := s.db.createEvent(ctx, event)
+	var id string
+	err = db.fenced(ctx, event.TargetID, func(ctx context.Context) (err error) {
+		id, err = db.createEvent(ctx, event)
+		return err
+	})
 	if err != nil {
 		return "", fmt.Errorf("db.createEvent: %w", err)
 	}
 
 	// Save the event to cache
-	if err = s.cache.Set(ctx, s.makeKey(eventKeyPrefix, event.ID), eventToDTO(event), cacheTTL); err != nil {
-		s.l.Error(
-			"saveEvent.cache.Set", zap.String("key", s.makeKey(eventKeyPrefix, event.ID)), zap.Error(err),
-		)
-	}
+	s.setCache(ctx, "SaveEvent", s.makeKey(ctx, eventKeyPrefix, event.ID), dto)
 
 	return id, nil
 }
 
-func (s *storage) updateEvent(ctx context.Context, event Event) error {
+func (s *storage) UpdateEvent(ctx context.Context, event Event) error {
+	db, err := s.repo(ctx)
+	if err != nil {
+		return err
+	}
+
+	dto, err := eventToDTO(event)
+	if err != nil {
+		return err
+	}
+
 	// Update the event in the database
-	if err := s.db.updateEvent(ctx, event); err != nil {
+	err = db.fenced(ctx, event.TargetID, func(ctx context.Context) error {
+		return db.updateEvent(ctx, event)
+	})
+	if err != nil {
 		return fmt.Errorf("db.updateEvent: %w", err)
 	}
 
 	// Update the event in cache
-	if err := s.cache.Set(ctx, s.makeKey(eventKeyPrefix, event.ID), eventToDTO(event), cacheTTL); err != nil {
-		s.l.Error(
-			"updateEvent.cache.Set", zap.String("key", s.makeKey(eventKeyPrefix, event.ID)), zap.Error(err),
-		)
+	s.setCache(ctx, "UpdateEvent", s.makeKey(ctx, eventKeyPrefix, event.ID), dto)
+
+	return nil
+}
+
+func (s *storage) GetEvents(ctx context.Context, targetID string, filter EventsFilter) (EventPage, error) {
+	db, err := s.repo(ctx)
+	if err != nil {
+		return EventPage{}, err
+	}
+
+	page, err := db.getEventsByTargetID(ctx, targetID, filter)
+	if err != nil {
+		return EventPage{}, fmt.Errorf("db.getEventsByTargetID: %w", err)
+	}
+
+	return page, nil
+}
+
+func (s *storage) GetLogs(ctx context.Context, targetID string, filter HistoryFilter) (LogPage, error) {
+	db, err := s.repo(ctx)
+	if err != nil {
+		return LogPage{}, err
+	}
+
+	page, err := db.getLogsByTargetID(ctx, targetID, filter)
+	if err != nil {
+		return LogPage{}, fmt.Errorf("db.getLogsByTargetID: %w", err)
+	}
+
+	return page, nil
+}
+
+func (s *storage) DeleteLogs(ctx context.Context, olderThan time.Duration, keepCount int) error {
+	db, err := s.repo(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err = db.deleteLogs(ctx, olderThan, keepCount); err != nil {
+		return fmt.Errorf("db.deleteLogs: %w", err)
 	}
 
 	return nil
 }
+
+func (s *storage) GetTargetState(ctx context.Context, targetID string) (TargetState, error) {
+	// Check the cache first
+	var stateDTO targetStateDto
+	if err := s.cache.Get(ctx, s.makeKey(ctx, targetKeyPrefix, targetID), &stateDTO); err != nil {
+		if !errors.Is(err, redis.Nil) {
+			s.l.Error(
+				"GetTargetState.s.cache.Get", zap.String("key", s.makeKey(ctx, targetKeyPrefix, targetID)), zap.Error(err),
+			)
+		}
+	} else {
+		return stateDTO.toTargetState(), nil
+	}
+
+	db, err := s.repo(ctx)
+	if err != nil {
+		return TargetState{}, err
+	}
+
+	state, err := db.getTargetState(ctx, targetID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return TargetState{}, ErrTargetNotFound
+		}
+
+		return TargetState{}, fmt.Errorf("db.getTargetState: %w", err)
+	}
+
+	// Save the state in cache
+	s.setCache(ctx, "GetTargetState", s.makeKey(ctx, targetKeyPrefix, targetID), targetStateToDTO(state))
+
+	return state, nil
+}
+
+func (s *storage) SwapTargetState(ctx context.Context, state TargetState) (int64, error) {
+	db, err := s.repo(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	key := s.makeKey(ctx, targetKeyPrefix, state.TargetID)
+
+	var version int64
+	err = db.fenced(ctx, state.TargetID, func(ctx context.Context) (err error) {
+		version, err = db.swapTargetState(ctx, state)
+		return err
+	})
+	if err != nil {
+		// the cached state may be stale, the next read goes to the database
+		if err := s.cache.Del(ctx, key); err != nil {
+			s.l.Error("SwapTargetState.cache.Del", zap.String("key", key), zap.Error(err))
+		}
+
+		if errors.Is(err, ErrConcurrentModification) {
+			return 0, err
+		}
+
+		return 0, fmt.Errorf("db.swapTargetState: %w", err)
+	}
+
+	// Update the state in cache
+	state.Version, state.UpdatedAt = version, time.Now()
+	afterCommit(ctx, func() {
+		ctx := context.WithoutCancel(ctx)
+		if err := s.cache.Set(ctx, key, targetStateToDTO(state), cacheTTL); err != nil {
+			s.l.Error("SwapTargetState.cache.Set", zap.String("key", key), zap.Error(err))
+
+			if err := s.cache.Del(ctx, key); err != nil {
+				s.l.Error("SwapTargetState.cache.Del", zap.String("key", key), zap.Error(err))
+			}
+		}
+	})
+
+	return version, nil
+}
+
+func (s *storage) GetEventByIdempotencyKey(ctx context.Context, targetID, key string) (Event, error) {
+	db, err := s.repo(ctx)
+	if err != nil {
+		return Event{}, err
+	}
+
+	event, err := db.getEventByIdempotencyKey(ctx, targetID, key)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Event{}, ErrLastLogNotFound
+		}
+
+		return Event{}, fmt.Errorf("db.getEventByIdempotencyKey: %w", err)
+	}
+
+	return event, nil
+}
+
+func (s *storage) ExpireIdempotencyKeys(ctx context.Context, olderThan time.Duration) error {
+	db, err := s.repo(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err = db.expireIdempotencyKeys(ctx, olderThan); err != nil {
+		return fmt.Errorf("db.expireIdempotencyKeys: %w", err)
+	}
+
+	return nil
+}
+
+func (s *storage) ExpireIdempotencyKey(ctx context.Context, targetID, key string, olderThan time.Duration) error {
+	db, err := s.repo(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err = db.expireIdempotencyKey(ctx, targetID, key, olderThan); err != nil {
+		return fmt.Errorf("db.expireIdempotencyKey: %w", err)
+	}
+
+	return nil
+}
+
+func (s *storage) ClaimAbandonedLogs(ctx context.Context, olderThan time.Duration, limit int) ([]Log, error) {
+	db, err := s.repo(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	logs, err := db.claimAbandonedLogs(ctx, olderThan, limit)
+	if err != nil {
+		return nil, fmt.Errorf("db.claimAbandonedLogs: %w", err)
+	}
+
+	return logs, nil
+}
+
+func (s *storage) SaveScheduledEvent(ctx context.Context, event ScheduledEvent) (string, error) {
+	db, err := s.repo(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	id, err := db.createScheduledEvent(ctx, event)
+	if err != nil {
+		return "", fmt.Errorf("db.createScheduledEvent: %w", err)
+	}
+
+	return id, nil
+}
+
+func (s *storage) DeleteScheduledEvent(ctx context.Context, id string) error {
+	db, err := s.repo(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err = db.deleteScheduledEvent(ctx, id); err != nil {
+		return fmt.Errorf("db.deleteScheduledEvent: %w", err)
+	}
+
+	return nil
+}
+
+func (s *storage) DeleteBoundScheduledEvents(ctx context.Context, targetID string, state StateName) error {
+	db, err := s.repo(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err = db.deleteBoundScheduledEvents(ctx, targetID, state); err != nil {
+		return fmt.Errorf("db.deleteBoundScheduledEvents: %w", err)
+	}
+
+	return nil
+}
+
+func (s *storage) ClaimScheduledEvents(ctx context.Context, limit int, lease time.Duration) ([]ScheduledEvent, error) {
+	db, err := s.repo(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := db.claimScheduledEvents(ctx, limit, lease)
+	if err != nil {
+		return nil, fmt.Errorf("db.claimScheduledEvents: %w", err)
+	}
+
+	return events, nil
+}
+
+func (s *storage) SaveOutboxEntry(ctx context.Context, entry OutboxEntry) error {
+	db, err := s.repo(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err = db.createOutboxEntry(ctx, entry); err != nil {
+		return fmt.Errorf("db.createOutboxEntry: %w", err)
+	}
+
+	return nil
+}
+
+func (s *storage) ClaimOutboxEntries(ctx context.Context, limit int, lease time.Duration) ([]OutboxEntry, error) {
+	db, err := s.repo(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := db.claimOutboxEntries(ctx, limit, lease)
+	if err != nil {
+		return nil, fmt.Errorf("db.claimOutboxEntries: %w", err)
+	}
+
+	return entries, nil
+}
+
+func (s *storage) DeleteOutboxEntries(ctx context.Context, ids []string) error {
+	db, err := s.repo(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err = db.deleteOutboxEntries(ctx, ids); err != nil {
+		return fmt.Errorf("db.deleteOutboxEntries: %w", err)
+	}
+
+	return nil
+}
+
+func (s *storage) EnqueueEvent(ctx context.Context, event QueuedEvent) (string, error) {
+	db, err := s.repo(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	id, err := db.createQueuedEvent(ctx, event)
+	if err != nil {
+		return "", fmt.Errorf("db.createQueuedEvent: %w", err)
+	}
+
+	return id, nil
+}
+
+func (s *storage) ClaimQueuedEvents(ctx context.Context, limit int, visibility time.Duration) ([]QueuedEvent, error) {
+	db, err := s.repo(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := db.claimQueuedEvents(ctx, limit, visibility)
+	if err != nil {
+		return nil, fmt.Errorf("db.claimQueuedEvents: %w", err)
+	}
+
+	return events, nil
+}
+
+func (s *storage) DeleteQueuedEvent(ctx context.Context, id string) error {
+	db, err := s.repo(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err = db.deleteQueuedEvent(ctx, id); err != nil {
+		return fmt.Errorf("db.deleteQueuedEvent: %w", err)
+	}
+
+	return nil
+}
+
+func (s *storage) SaveDeadLetter(ctx context.Context, dl DeadLetter) (string, error) {
+	db, err := s.repo(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	id, err := db.createDeadLetter(ctx, dl)
+	if err != nil {
+		return "", fmt.Errorf("db.createDeadLetter: %w", err)
+	}
+
+	return id, nil
+}
+
+func (s *storage) GetDeadLetter(ctx context.Context, id string) (DeadLetter, error) {
+	db, err := s.repo(ctx)
+	if err != nil {
+		return DeadLetter{}, err
+	}
+
+	dl, err := db.getDeadLetterByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return DeadLetter{}, ErrDeadLetterNotFound
+		}
+
+		return DeadLetter{}, fmt.Errorf("db.getDeadLetterByID: %w", err)
+	}
+
+	return dl, nil
+}
+
+func (s *storage) GetDeadLetters(ctx context.Context, filter DeadLetterFilter) (DeadLetterPage, error) {
+	db, err := s.repo(ctx)
+	if err != nil {
+		return DeadLetterPage{}, err
+	}
+
+	page, err := db.getDeadLetters(ctx, filter)
+	if err != nil {
+		return DeadLetterPage{}, fmt.Errorf("db.getDeadLetters: %w", err)
+	}
+
+	return page, nil
+}
+
+func (s *storage) DeleteDeadLetter(ctx context.Context, id string) error {
+	db, err := s.repo(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err = db.deleteDeadLetter(ctx, id); err != nil {
+		return fmt.Errorf("db.deleteDeadLetter: %w", err)
+	}
+
+	return nil
+}
+
+func (s *storage) RunInTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	db, err := s.repo(ctx)
+	if err != nil {
+		return err
+	}
+
+	return runInTx(ctx, db.store.db, fn)
+}
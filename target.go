@@ -38,6 +38,31 @@ type Target[T comparable] struct {
 	state       *State[T]
 	stateResult ResultStatus
 
+	payload EventPayload
+
+	// resumeStatus is applied to the current state instead of its execution, see FSM.ProcessEventWithStatus
+	resumeStatus ResultStatus
+
+	// resumeEventID is the existing event the target is processed within, see Recovery
+	resumeEventID string
+
+	// startState replaces the current state of the target, see FSM.ReplayDeadLetter
+	startState StateName
+
+	// entered is true until the log of the current state the target has entered within the event is saved,
+	// the log is saved with the outbox entry, see Config.Outbox
+	entered bool
+
+	// boundState is the state the scheduled event is bound to, the event is dropped
+	// if the target has left the state by the time it is locked, see Scheduler
+	boundState StateName
+
+	idempotencyKey string
+	duplicate      bool
+
+	// version is the version of the target state read before processing, see TargetStore
+	version int64
+
 	data TargetData[T]
 }
 
@@ -57,12 +82,34 @@ func (e *Target[T]) ID() string {
 	return e.id
 }
 
+// EventID returns the ID of the event the target was processed with
+func (e *Target[T]) EventID() string {
+	return e.eventID
+}
+
+// CurrentState returns the state the target is in after processing of the event
+func (e *Target[T]) CurrentState() StateName {
+	if e.state == nil {
+		return ""
+	}
+
+	return e.state.Name
+}
+
+// LastResultStatus returns the result status of the last executed state
+func (e *Target[T]) LastResultStatus() ResultStatus {
+	return e.stateResult
+}
+
 func (e *Target[T]) event() Event {
 	return Event{
 		ID:               e.eventID,
 		TargetID:         e.data.ID(),
+		CurrentState:     e.CurrentState(),
 		LastResultStatus: e.stateResult,
 		MetaInfo:         e.data.MetaInfo(),
+		Payload:          e.payload,
+		IdempotencyKey:   e.idempotencyKey,
 	}
 }
 
@@ -72,6 +119,7 @@ func (e *Target[T]) log() Log {
 		EventID:             e.eventID,
 		CurrentStateName:    e.state.Name,
 		CurrentResultStatus: e.stateResult,
+		Attempt:             1,
 		CreatedAt:           time.Now(),
 		UpdatedAt:           time.Now(),
 	}
@@ -0,0 +1,171 @@
+package event_fsm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TargetState is the current state of the target kept by the FSM
+type TargetState struct {
+	TargetID string
+	State    StateName
+
+	// Version is increased by every transition of the target, 0 if the target is not stored yet
+	Version int64
+
+	UpdatedAt time.Time
+}
+
+type targetStateDto struct {
+	TargetID     string    `db:"target_id" json:"target_id"`
+	CurrentState string    `db:"current_state" json:"current_state"`
+	Version      int64     `db:"version" json:"version"`
+	UpdatedAt    time.Time `db:"updated_at" json:"updated_at"`
+}
+
+func (t *targetStateDto) toTargetState() TargetState {
+	return TargetState{
+		TargetID:  t.TargetID,
+		State:     StateName(t.CurrentState),
+		Version:   t.Version,
+		UpdatedAt: t.UpdatedAt,
+	}
+}
+
+func targetStateToDTO(t TargetState) targetStateDto {
+	return targetStateDto{
+		TargetID:     t.TargetID,
+		CurrentState: t.State.String(),
+		Version:      t.Version,
+		UpdatedAt:    t.UpdatedAt,
+	}
+}
+
+// TargetStore is implemented by the stores which can keep the current states of the targets
+type TargetStore interface {
+	// GetTargetState returns the state of the target, ErrTargetNotFound if it is not stored
+	GetTargetState(ctx context.Context, targetID string) (TargetState, error)
+
+	// SwapTargetState saves the state if the stored version equals state.Version,
+	// the target with version 0 must not be stored yet.
+	// It returns the new version or ErrConcurrentModification if the version has changed.
+	SwapTargetState(ctx context.Context, state TargetState) (int64, error)
+}
+
+// ManagedTargetData is the data of the target whose state is kept by the FSM, see Config.ManagedState
+type ManagedTargetData[T comparable] interface {
+	// Data returns the data of the object, whose state is being processed
+	Data() T
+
+	// IsNull true if the data is null
+	IsNull() bool
+
+	// ID unique ID of the object, whose state is being processed
+	ID() string
+
+	// MetaInfo additional information about the event of the object, JSON format
+	MetaInfo() json.RawMessage
+}
+
+// NewManagedTargetData makes TargetData of the target whose state is kept by the FSM.
+// The state is loaded by the FSM when the event is processed, Save does nothing.
+func NewManagedTargetData[T comparable](data ManagedTargetData[T]) TargetData[T] {
+	return &managedTargetData[T]{ManagedTargetData: data}
+}
+
+type managedTargetData[T comparable] struct {
+	ManagedTargetData[T]
+
+	state StateName
+}
+
+func (d *managedTargetData[T]) GetState() StateName {
+	return d.state
+}
+
+func (d *managedTargetData[T]) SetState(state StateName) {
+	d.state = state
+}
+
+func (d *managedTargetData[T]) Save(_ context.Context) error {
+	return nil
+}
+
+// CurrentState returns the current state of the target kept by the FSM,
+// ErrTargetNotFound if the target has not processed any event yet.
+// It requires Config.ManagedState or Config.VersionedTargets.
+func (f *FSM[T]) CurrentState(ctx context.Context, targetID string) (StateName, error) {
+	if f.targets == nil {
+		return "", fmt.Errorf("current state: %w", ErrNotSupported)
+	}
+
+	state, err := f.targets.GetTargetState(ctx, targetID)
+	if err != nil {
+		return "", fmt.Errorf("f.targets.GetTargetState: %w", err)
+	}
+
+	return state.State, nil
+}
+
+// stateOf returns the current state of the target, it is empty for the new target
+func (f *FSM[T]) stateOf(ctx context.Context, data TargetData[T]) (StateName, error) {
+	if !f.managedState {
+		return data.GetState(), nil
+	}
+
+	state, err := f.CurrentState(ctx, data.ID())
+	if err != nil && !errors.Is(err, ErrTargetNotFound) {
+		return "", err
+	}
+
+	return state, nil
+}
+
+// Version returns the version of the target state, see Config.VersionedTargets
+func (e *Target[T]) Version() int64 {
+	return e.version
+}
+
+// loadVersion reads the version of the target before processing of the event,
+// the state of the target is loaded too if it is kept by the FSM
+func (f *FSM[T]) loadVersion(ctx context.Context, t *Target[T]) error {
+	if f.targets == nil {
+		return nil
+	}
+
+	state, err := f.targets.GetTargetState(ctx, t.ID())
+	if err != nil && !errors.Is(err, ErrTargetNotFound) {
+		return fmt.Errorf("f.targets.GetTargetState: %w", err)
+	}
+
+	t.version = state.Version
+
+	if f.managedState {
+		t.data.SetState(state.State)
+	}
+
+	return nil
+}
+
+// swapState saves the new state of the target if nobody has changed it since its version was read
+func (f *FSM[T]) swapState(ctx context.Context, t *Target[T]) error {
+	if f.targets == nil {
+		return nil
+	}
+
+	version, err := f.targets.SwapTargetState(ctx, TargetState{
+		TargetID: t.ID(),
+		State:    t.state.Name,
+		Version:  t.version,
+	})
+	if err != nil {
+		return fmt.Errorf("f.targets.SwapTargetState: %w", err)
+	}
+
+	t.version = version
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package event_fsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// stateConcurrent changes the target state in the store while it is executed
+type stateConcurrent struct {
+	store *MemoryStore
+}
+
+func (s *stateConcurrent) Execute(ctx context.Context, data *eventData) (ResultStatus, error) {
+	state, _ := s.store.GetTargetState(ctx, data.ID())
+	_, _ = s.store.SwapTargetState(ctx, state)
+
+	return ResultStatusOk, nil
+}
+
+func TestVersionedTargets(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+
+	sd := NewStateDetector[*eventData]()
+	first := sd.NewState(StateFirstCheck, &stateFlaky{}, StateTypeTransition)
+	wait := sd.NewState(StateManualAdd, &stateConcurrent{store: store}, StateTypeWaitEvent)
+	lastCheck := sd.NewState(StateLastCheck, &stateFlaky{}, StateTypeWaitEvent)
+	first.SetNext(wait, ResultStatusOk)
+	wait.SetNext(lastCheck, ResultStatusOk)
+	lastCheck.SetTerminal()
+	sd.SetMainState(StateFirstCheck)
+
+	fsm, err := NewFSM(&Config[*eventData]{
+		Logger:           zap.NewNop(),
+		StateDetector:    sd,
+		Store:            store,
+		VersionedTargets: true,
+	})
+	if err != nil {
+		t.Fatalf("NewFSM: %v", err)
+	}
+
+	ed := newEventData(1)
+	target, err := fsm.ProcessEvent(ctx, NewTarget(&ed))
+	if err != nil {
+		t.Fatalf("ProcessEvent: %v", err)
+	}
+
+	state, err := store.GetTargetState(ctx, ed.ID())
+	if err != nil || state.State != StateManualAdd || state.Version != 1 || target.Version() != 1 {
+		t.Fatalf("expected %s of version 1, got %+v, %v", StateManualAdd, state, err)
+	}
+
+	if _, err = fsm.ProcessEvent(ctx, NewTarget(&ed)); !errors.Is(err, ErrConcurrentModification) {
+		t.Fatalf("expected ErrConcurrentModification, got %v", err)
+	}
+
+	if ed.GetState() != StateManualAdd {
+		t.Fatalf("expected the target not saved, got %s", ed.GetState())
+	}
+}
+
+func TestManagedState(t *testing.T) {
+	ctx := context.Background()
+
+	sd := NewStateDetector[*eventData]()
+	first := sd.NewState(StateFirstCheck, &stateFlaky{}, StateTypeTransition)
+	wait := sd.NewState(StateManualAdd, &stateFlaky{}, StateTypeWaitEvent)
+	lastCheck := sd.NewState(StateLastCheck, &stateFlaky{}, StateTypeWaitEvent)
+	first.SetNext(wait, ResultStatusOk)
+	wait.SetNext(lastCheck, ResultStatusOk)
+	lastCheck.SetTerminal()
+	sd.SetMainState(StateFirstCheck)
+
+	fsm, err := NewFSM(&Config[*eventData]{
+		Logger:        zap.NewNop(),
+		StateDetector: sd,
+		Store:         NewMemoryStore(),
+		ManagedState:  true,
+	})
+	if err != nil {
+		t.Fatalf("NewFSM: %v", err)
+	}
+
+	ed := newEventData(1)
+	initial := ed.GetState()
+
+	if _, err = fsm.CurrentState(ctx, ed.ID()); !errors.Is(err, ErrTargetNotFound) {
+		t.Fatalf("expected ErrTargetNotFound, got %v", err)
+	}
+
+	for _, want := range []StateName{StateManualAdd, StateLastCheck} {
+		if _, err = fsm.ProcessEvent(ctx, NewTarget(NewManagedTargetData[*eventData](&ed))); err != nil {
+			t.Fatalf("ProcessEvent: %v", err)
+		}
+
+		if state, err := fsm.CurrentState(ctx, ed.ID()); err != nil || state != want {
+			t.Fatalf("expected state %s, got %s, %v", want, state, err)
+		}
+	}
+
+	if ed.GetState() != initial {
+		t.Fatalf("expected the state of the data untouched, got %s", ed.GetState())
+	}
+}
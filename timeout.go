@@ -0,0 +1,70 @@
+package event_fsm
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// StateTimeout is the transition of the state waiting for the next event,
+// which is taken when no event arrives in time
+type StateTimeout struct {
+	// After is the time the state waits for the next event
+	After time.Duration
+
+	// Status is applied to the state when the time is out
+	Status ResultStatus
+}
+
+// SetTimeout makes the state waiting for the next event transition with the status after the duration.
+// The timeouts are persisted as the scheduled events bound to the state and fired by the Scheduler,
+// the timeout is canceled when the state is finished by the next event of the target,
+// it is kept if the state fails or the step is rolled back.
+func (s *State[T]) SetTimeout(after time.Duration, status ResultStatus) {
+	s.Timeout = &StateTimeout{After: after, Status: status}
+}
+
+// hasTimeouts reports whether any state of the detector has the timeout
+func (sd *StateDetector[T]) hasTimeouts() bool {
+	for _, state := range sd.states {
+		if state.Timeout != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// scheduleTimeout schedules the timeout of the state the target waits in
+func (f *FSM[T]) scheduleTimeout(ctx context.Context, t Target[T]) error {
+	store, ok := f.store.(SchedulerStore)
+	if !ok || t.state.Timeout == nil {
+		return nil
+	}
+
+	if _, err := store.SaveScheduledEvent(ctx, ScheduledEvent{
+		TargetID: t.ID(),
+		EventID:  t.eventID,
+		State:    t.state.Name,
+		Status:   t.state.Timeout.Status,
+		RunAt:    time.Now().Add(t.state.Timeout.After),
+	}); err != nil {
+		return fmt.Errorf("store.SaveScheduledEvent: %w", err)
+	}
+
+	return nil
+}
+
+// cancelTimeouts cancels the timeouts of the state the target waits in, the state is finished
+func (f *FSM[T]) cancelTimeouts(ctx context.Context, t Target[T]) error {
+	store, ok := f.store.(SchedulerStore)
+	if !ok || t.state.Timeout == nil {
+		return nil
+	}
+
+	if err := store.DeleteBoundScheduledEvents(ctx, t.ID(), t.state.Name); err != nil {
+		return fmt.Errorf("store.DeleteBoundScheduledEvents: %w", err)
+	}
+
+	return nil
+}
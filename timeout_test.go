@@ -0,0 +1,90 @@
+package event_fsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestStateTimeout(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name         string
+		eventArrives bool
+		failures     int
+		want         StateName
+	}{
+		{name: "no event", want: StateLastCheck},
+		{name: "event arrives", eventArrives: true, want: StatePrintResult},
+		// the failed state keeps waiting for its timeout
+		{name: "executor fails", eventArrives: true, failures: 1, want: StateLastCheck},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sd := NewStateDetector[*eventData]()
+			first := sd.NewState(StateFirstCheck, &stateFlaky{}, StateTypeTransition)
+			wait := sd.NewState(StateManualAdd, &stateFlaky{failures: tt.failures}, StateTypeWaitEvent)
+			wait.SetTimeout(time.Millisecond, WrongNumber)
+			printResult := sd.NewState(StatePrintResult, &stateFlaky{}, StateTypeTransition)
+			printResult.SetTerminal()
+			lastCheck := sd.NewState(StateLastCheck, &stateFlaky{}, StateTypeTransition)
+			lastCheck.SetTerminal()
+			first.SetNext(wait, ResultStatusOk)
+			wait.SetNext(printResult, ResultStatusOk)
+			wait.SetNext(lastCheck, WrongNumber)
+			sd.SetMainState(StateFirstCheck)
+
+			fsm, err := NewFSM(&Config[*eventData]{Logger: zap.NewNop(), StateDetector: sd, Store: NewMemoryStore()})
+			if err != nil {
+				t.Fatalf("NewFSM: %v", err)
+			}
+
+			ed := newEventData(1)
+			scheduler, err := NewScheduler(SchedulerConfig[*eventData]{
+				FSM: fsm,
+				Load: func(ctx context.Context, targetID string) (TargetData[*eventData], error) {
+					return &ed, nil
+				},
+			})
+			if err != nil {
+				t.Fatalf("NewScheduler: %v", err)
+			}
+
+			if _, err = fsm.ProcessEvent(ctx, NewTarget(&ed)); err != nil {
+				t.Fatalf("ProcessEvent: %v", err)
+			}
+
+			if tt.eventArrives {
+				_, err = fsm.ProcessEvent(ctx, NewTarget(&ed))
+
+				var execErr *ExecutionError
+				switch {
+				case tt.failures == 0 && err != nil:
+					t.Fatalf("ProcessEvent: %v", err)
+				case tt.failures > 0 && !errors.As(err, &execErr):
+					t.Fatalf("expected ExecutionError, got %v", err)
+				}
+			}
+
+			time.Sleep(5 * time.Millisecond)
+
+			n, err := scheduler.Poll(ctx)
+			if err != nil {
+				t.Fatalf("Poll: %v", err)
+			}
+
+			if fired := tt.want == StateLastCheck; fired != (n != 0) {
+				t.Fatalf("expected the timeout fired: %v, fired timeouts: %d", fired, n)
+			}
+
+			if ed.GetState() != tt.want {
+				t.Fatalf("expected state %s, got %s", tt.want, ed.GetState())
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package event_fsm
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const tracerName = "github.com/ivan-chepurin/event-fsm"
+
+// the attributes of the spans
+const (
+	attrTargetID     = attribute.Key("fsm.target_id")
+	attrEventID      = attribute.Key("fsm.event_id")
+	attrState        = attribute.Key("fsm.state")
+	attrAttempt      = attribute.Key("fsm.attempt")
+	attrResultStatus = attribute.Key("fsm.result_status")
+)
+
+// newTracer returns the tracer of the provider, the tracer creating no spans if the provider is nil
+func newTracer(tp trace.TracerProvider) trace.Tracer {
+	if tp == nil {
+		tp = noop.NewTracerProvider()
+	}
+
+	return tp.Tracer(tracerName)
+}
+
+// endSpan records the error in the span and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+// withTrace links the log to the span of the context, the log is not changed if there is no span
+func withTrace(ctx context.Context, log Log) Log {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return log
+	}
+
+	log.TraceID, log.SpanID = sc.TraceID().String(), sc.SpanID().String()
+
+	return log
+}
+
+// tracedClient creates the span for every query of the database made with the context
+type tracedClient struct {
+	sqlClient
+
+	tracer trace.Tracer
+}
+
+func (c tracedClient) start(ctx context.Context, query string) (context.Context, trace.Span) {
+	op, _, _ := strings.Cut(strings.TrimSpace(query), " ")
+
+	return c.tracer.Start(ctx, "fsm.db "+strings.ToUpper(op),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.statement", query),
+		),
+	)
+}
+
+func (c tracedClient) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	ctx, span := c.start(ctx, query)
+	err := c.sqlClient.GetContext(ctx, dest, query, args...)
+	endSpan(span, err)
+
+	return err
+}
+
+func (c tracedClient) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	ctx, span := c.start(ctx, query)
+	err := c.sqlClient.SelectContext(ctx, dest, query, args...)
+	endSpan(span, err)
+
+	return err
+}
+
+func (c tracedClient) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, span := c.start(ctx, query)
+	res, err := c.sqlClient.ExecContext(ctx, query, args...)
+	endSpan(span, err)
+
+	return res, err
+}
+
+func (c tracedClient) NamedExecContext(ctx context.Context, query string, arg interface{}) (sql.Result, error) {
+	ctx, span := c.start(ctx, query)
+	res, err := c.sqlClient.NamedExecContext(ctx, query, arg)
+	endSpan(span, err)
+
+	return res, err
+}
+
+func (c tracedClient) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, span := c.start(ctx, query)
+	rows, err := c.sqlClient.QueryContext(ctx, query, args...)
+	endSpan(span, err)
+
+	return rows, err
+}
+
+func (c tracedClient) QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error) {
+	ctx, span := c.start(ctx, query)
+	rows, err := c.sqlClient.QueryxContext(ctx, query, args...)
+	endSpan(span, err)
+
+	return rows, err
+}
+
+func (c tracedClient) QueryRowxContext(ctx context.Context, query string, args ...interface{}) *sqlx.Row {
+	ctx, span := c.start(ctx, query)
+	row := c.sqlClient.QueryRowxContext(ctx, query, args...)
+	endSpan(span, row.Err())
+
+	return row
+}
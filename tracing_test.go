@@ -0,0 +1,86 @@
+package event_fsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/zap"
+)
+
+func TestTracing(t *testing.T) {
+	ctx := context.Background()
+
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	sd := NewStateDetector[*eventData]()
+	first := sd.NewState(StateFirstCheck, &stateFlaky{}, StateTypeTransition)
+	add := sd.NewState(StateAdd3, &stateFailing{}, StateTypeTransition)
+	add.SetTerminal()
+	first.SetNext(add, ResultStatusOk)
+	sd.SetMainState(StateFirstCheck)
+
+	fsm, err := NewFSM(&Config[*eventData]{
+		Logger:         zap.NewNop(),
+		StateDetector:  sd,
+		Store:          NewMemoryStore(),
+		TracerProvider: tp,
+	})
+	if err != nil {
+		t.Fatalf("NewFSM: %v", err)
+	}
+
+	ed := newEventData(1)
+	target, err := fsm.ProcessEvent(ctx, NewTarget(&ed))
+
+	var execErr *ExecutionError
+	if !errors.As(err, &execErr) {
+		t.Fatalf("expected ExecutionError, got %v", err)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("expected 3 spans, got %d", len(spans))
+	}
+
+	process := spans[2]
+	if process.Name() != "fsm.ProcessEvent" || process.Status().Description == "" {
+		t.Fatalf("expected the failed span of the event, got %s %v", process.Name(), process.Status())
+	}
+
+	attrs := make(map[string]string)
+	for _, kv := range process.Attributes() {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+
+	if attrs["fsm.target_id"] != ed.ID() || attrs["fsm.event_id"] != target.EventID() ||
+		attrs["fsm.state"] != StateFirstCheck.String() {
+		t.Fatalf("unexpected attributes of the event span %v", attrs)
+	}
+
+	for i, state := range []StateName{StateFirstCheck, StateAdd3} {
+		span := spans[i]
+		if span.Name() != "fsm.state "+state.String() || span.Parent().SpanID() != process.SpanContext().SpanID() {
+			t.Fatalf("expected the child span of %s, got %s", state, span.Name())
+		}
+	}
+
+	if len(spans[1].Events()) == 0 {
+		t.Fatalf("expected the error recorded in the span of %s", StateAdd3)
+	}
+
+	page, _ := fsm.History(ctx, ed.ID(), HistoryFilter{})
+	for _, log := range page.Logs {
+		span := spans[0]
+		if log.CurrentStateName == StateAdd3 {
+			span = spans[1]
+		}
+
+		if log.TraceID != span.SpanContext().TraceID().String() || log.SpanID != span.SpanContext().SpanID().String() {
+			t.Fatalf("expected the log of %s linked to its span, got %s/%s", log.CurrentStateName, log.TraceID, log.SpanID)
+		}
+	}
+}
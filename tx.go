@@ -0,0 +1,178 @@
+package event_fsm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type txKey struct{}
+
+// txState is the transaction of the context with the actions postponed until it is committed
+type txState struct {
+	tx *sqlx.Tx
+
+	mu          sync.Mutex
+	afterCommit []func()
+}
+
+// TxStore is implemented by the stores which can run the writes in one database transaction
+type TxStore interface {
+	// RunInTx runs fn in the transaction, which is committed if fn returns nil and rolled back otherwise.
+	// The transaction is passed to fn in the context, see TxFromContext.
+	// If the context already has the transaction, fn joins it.
+	RunInTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// TxFromContext returns the transaction the FSM runs the step in, see Config.Transactional.
+// TargetData.Save and the executors can use it to commit or roll back their writes with the step.
+func TxFromContext(ctx context.Context) (*sqlx.Tx, bool) {
+	state, ok := ctx.Value(txKey{}).(*txState)
+	if !ok {
+		return nil, false
+	}
+
+	return state.tx, true
+}
+
+// afterCommit runs fn when the transaction of the context is committed, immediately if there is none
+func afterCommit(ctx context.Context, fn func()) {
+	state, ok := ctx.Value(txKey{}).(*txState)
+	if !ok {
+		fn()
+		return
+	}
+
+	state.mu.Lock()
+	state.afterCommit = append(state.afterCommit, fn)
+	state.mu.Unlock()
+}
+
+// runInTx runs fn in the new transaction of the database unless the context already has one
+func runInTx(ctx context.Context, db *sqlx.DB, fn func(ctx context.Context) error) (err error) {
+	if _, ok := TxFromContext(ctx); ok {
+		return fn(ctx)
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("db.BeginTxx: %w", err)
+	}
+
+	state := &txState{tx: tx}
+
+	committed := false
+	defer func() {
+		if !committed {
+			if rbErr := tx.Rollback(); rbErr != nil && !errors.Is(rbErr, sql.ErrTxDone) {
+				err = errors.Join(err, fmt.Errorf("tx.Rollback: %w", rbErr))
+			}
+		}
+	}()
+
+	if err = fn(context.WithValue(ctx, txKey{}, state)); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("tx.Commit: %w", err)
+	}
+
+	committed = true
+
+	for _, fn := range state.afterCommit {
+		fn()
+	}
+
+	return nil
+}
+
+// runStep runs one step of processing, the state and the transition to the next one,
+// in the transaction if Config.Transactional is set.
+//
+// The step is committed when the state is finished, including the failed state and the missing transition,
+// which are recorded in the logs. It is rolled back if the executor returns an error or panics,
+// or the persistence of the step fails; the failure of the executor is recorded after the rollback.
+// Every attempt of the state retried by RetryPolicy runs in its own transaction, the backoff is outside of it.
+func (f *FSM[T]) runStep(ctx context.Context, t *Target[T]) (bool, error) {
+	if f.tx == nil {
+		return f.step(ctx, t, 1)
+	}
+
+	for attempt := 1; ; {
+		var (
+			snapshot = *t
+			state    = t.getStateName()
+			done     bool
+			stepErr  error
+		)
+
+		err := f.tx.RunInTx(ctx, func(ctx context.Context) error {
+			done, stepErr = f.step(ctx, t, attempt)
+			if stepErr != nil && !errors.Is(stepErr, ErrStateFailed) && !errors.Is(stepErr, ErrNoNextState) {
+				return stepErr
+			}
+
+			return nil
+		})
+		if err == nil {
+			return done, stepErr
+		}
+
+		// the step is rolled back, the target is restored
+		*t = snapshot
+		t.data.SetState(state)
+
+		var execErr *ExecutionError
+		if !errors.As(err, &execErr) {
+			return true, err
+		}
+
+		if recErr := f.recordFailure(ctx, t, execErr); recErr != nil {
+			return true, errors.Join(err, recErr)
+		}
+
+		retry := t.state.Retry
+		if retry.shouldRetry(attempt, execErr.Err) {
+			if err = sleep(ctx, retry.backoff(attempt)); err != nil {
+				return true, fmt.Errorf("retry of state %s: %w", t.state.Name, err)
+			}
+
+			attempt++
+			continue
+		}
+
+		if retry != nil && retry.ExhaustedStatus != ResultStatusEmpty {
+			// the retries are exhausted, the state transitions with the configured status in the new transaction,
+			// it is recorded with the last attempt
+			t.resumeStatus = retry.ExhaustedStatus
+			continue
+		}
+
+		return true, err
+	}
+}
+
+// recordFailure records the failure of the executor which rolled back the step
+func (f *FSM[T]) recordFailure(ctx context.Context, t *Target[T], execErr *ExecutionError) error {
+	t.stateResult = ResultStatusFail
+
+	log := withTrace(ctx, t.log())
+	log.Attempt = execErr.Attempt
+	log.Error = errorText(execErr.Err)
+
+	// the log of entering the state is rolled back with the step
+	if _, err := f.saveLog(ctx, t, log, f.store.CreateFullLog); err != nil {
+		return fmt.Errorf("f.store.CreateFullLog: %w", err)
+	}
+
+	if err := f.store.UpdateEvent(ctx, t.event()); err != nil {
+		return fmt.Errorf("f.store.UpdateEvent: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,180 @@
+package event_fsm
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// txCountingStore counts the transactions of the steps without the database,
+// the logs written by the rolled back transaction are discarded
+type txCountingStore struct {
+	*MemoryStore
+
+	commits, rollbacks int
+}
+
+func (s *txCountingStore) RunInTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	s.mu.RLock()
+	logs, targetLogIDs := maps.Clone(s.logs), maps.Clone(s.targetLogIDs)
+	s.mu.RUnlock()
+
+	if err := fn(ctx); err != nil {
+		s.mu.Lock()
+		s.logs, s.targetLogIDs = logs, targetLogIDs
+		s.mu.Unlock()
+
+		s.rollbacks++
+		return err
+	}
+
+	s.commits++
+
+	return nil
+}
+
+func TestTransactionalSteps(t *testing.T) {
+	ctx := context.Background()
+	store := &txCountingStore{MemoryStore: NewMemoryStore()}
+
+	sd := NewStateDetector[*eventData]()
+	first := sd.NewState(StateFirstCheck, &stateFlaky{}, StateTypeTransition)
+	add := sd.NewState(StateAdd3, &stateFlaky{}, StateTypeTransition)
+	wait := sd.NewState(StateManualAdd, &stateFailing{}, StateTypeWaitEvent)
+	first.SetNext(add, ResultStatusOk)
+	add.SetNext(wait, ResultStatusOk)
+	wait.SetTerminal()
+	sd.SetMainState(StateFirstCheck)
+
+	fsm, err := NewFSM(&Config[*eventData]{
+		Logger:        zap.NewNop(),
+		StateDetector: sd,
+		Store:         store,
+		Transactional: true,
+	})
+	if err != nil {
+		t.Fatalf("NewFSM: %v", err)
+	}
+
+	ed := newEventData(1)
+	if _, err = fsm.ProcessEvent(ctx, NewTarget(&ed)); err != nil {
+		t.Fatalf("ProcessEvent: %v", err)
+	}
+
+	if store.commits != 2 || store.rollbacks != 0 {
+		t.Fatalf("expected 2 committed steps, got %d commits, %d rollbacks", store.commits, store.rollbacks)
+	}
+
+	_, err = fsm.ProcessEvent(ctx, NewTarget(&ed))
+
+	var execErr *ExecutionError
+	if !errors.As(err, &execErr) || store.rollbacks != 1 {
+		t.Fatalf("expected the step rolled back with ExecutionError, got %v, %d rollbacks", err, store.rollbacks)
+	}
+
+	if ed.GetState() != StateManualAdd {
+		t.Fatalf("expected the target restored to %s, got %s", StateManualAdd, ed.GetState())
+	}
+
+	page, _ := fsm.History(ctx, ed.ID(), HistoryFilter{Limit: 1})
+	if len(page.Logs) != 1 || page.Logs[0].CurrentResultStatus != ResultStatusFail || page.Logs[0].Error == "" {
+		t.Fatalf("expected the failure recorded after the rollback, got %+v", page.Logs)
+	}
+
+	if _, err = NewFSM(&Config[*eventData]{
+		Logger:        zap.NewNop(),
+		StateDetector: sd,
+		Store:         NewMemoryStore(),
+		Transactional: true,
+	}); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("expected ErrNotSupported, got %v", err)
+	}
+}
+
+func TestTransactionalRetry(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		failures  int
+		policy    RetryPolicy
+		wantState StateName
+		wantLogs  int
+	}{
+		{
+			name:      "retry succeeds",
+			failures:  1,
+			policy:    RetryPolicy{MaxAttempts: 3},
+			wantState: StateManualAdd,
+			wantLogs:  2,
+		},
+		{
+			name:      "exhausted status",
+			failures:  2,
+			policy:    RetryPolicy{MaxAttempts: 2, ExhaustedStatus: WrongNumber},
+			wantState: StatePrintResult,
+			wantLogs:  3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &txCountingStore{MemoryStore: NewMemoryStore()}
+
+			sd := NewStateDetector[*eventData]()
+			first := sd.NewState(StateFirstCheck, &stateFlaky{failures: tt.failures}, StateTypeTransition)
+			wait := sd.NewState(StateManualAdd, &stateFlaky{}, StateTypeWaitEvent)
+			printResult := sd.NewState(StatePrintResult, &stateFlaky{}, StateTypeWaitEvent)
+			first.SetRetryPolicy(tt.policy)
+			first.SetNext(wait, ResultStatusOk)
+			first.SetNext(printResult, WrongNumber)
+			wait.SetTerminal()
+			printResult.SetTerminal()
+			sd.SetMainState(StateFirstCheck)
+
+			fsm, err := NewFSM(&Config[*eventData]{
+				Logger:        zap.NewNop(),
+				StateDetector: sd,
+				Store:         store,
+				Transactional: true,
+			})
+			if err != nil {
+				t.Fatalf("NewFSM: %v", err)
+			}
+
+			ed := newEventData(1)
+			if _, err = fsm.ProcessEvent(ctx, NewTarget(&ed)); err != nil {
+				t.Fatalf("ProcessEvent: %v", err)
+			}
+
+			// every failed attempt is rolled back in its own transaction
+			if store.rollbacks != tt.failures || store.commits != 1 {
+				t.Fatalf("expected %d rollbacks and 1 commit, got %d, %d", tt.failures, store.rollbacks, store.commits)
+			}
+
+			if ed.GetState() != tt.wantState {
+				t.Fatalf("expected state %s, got %s", tt.wantState, ed.GetState())
+			}
+
+			page, err := fsm.History(ctx, ed.ID(), HistoryFilter{States: []StateName{StateFirstCheck}})
+			if err != nil || len(page.Logs) != tt.wantLogs {
+				t.Fatalf("expected %d logs of %s, got %+v, %v", tt.wantLogs, StateFirstCheck, page.Logs, err)
+			}
+
+			// the failures are recorded after the rollbacks
+			failed := 0
+			for _, log := range page.Logs {
+				if log.CurrentResultStatus == ResultStatusFail && log.Error != "" {
+					failed++
+				}
+			}
+
+			if failed != tt.failures {
+				t.Fatalf("expected %d failed logs, got %d", tt.failures, failed)
+			}
+		})
+	}
+}
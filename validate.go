@@ -0,0 +1,266 @@
+package event_fsm
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ValidationCheck is a structural check of the state graph made by StateDetector.Validate
+type ValidationCheck int
+
+const (
+	// CheckUnknownState reports the main state or the next states which are not registered with NewState
+	CheckUnknownState ValidationCheck = iota + 1
+
+	// CheckUnreachableState reports the states which can't be reached from the main state
+	CheckUnreachableState
+
+	// CheckTransitionCycle reports the cycles of StateTypeTransition states without StateTypeWaitEvent state,
+	// processing of the event never stops if the executors keep choosing such a cycle
+	CheckTransitionCycle
+
+	// CheckDeadEnd reports the states without next states which are not marked terminal with State.SetTerminal
+	CheckDeadEnd
+)
+
+func (c ValidationCheck) String() string {
+	switch c {
+	case CheckUnknownState:
+		return "unknown state"
+	case CheckUnreachableState:
+		return "unreachable state"
+	case CheckTransitionCycle:
+		return "transition cycle"
+	case CheckDeadEnd:
+		return "dead end"
+	default:
+		return fmt.Sprintf("check(%d)", int(c))
+	}
+}
+
+// ValidationError is a structural problem of the state graph
+type ValidationError struct {
+	Check ValidationCheck
+	State StateName
+	Msg   string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", e.Check, e.State, e.Msg)
+}
+
+// ValidationErrors is the list of all structural problems of the state graph
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+
+	return "invalid state graph: " + strings.Join(msgs, "; ")
+}
+
+func (e ValidationErrors) Unwrap() []error {
+	errs := make([]error, 0, len(e))
+	for _, err := range e {
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+// classify divides the problems into the warnings and the failures,
+// the problem fails unless its check is among the warnings
+func (e ValidationErrors) classify(warnings []ValidationCheck) (warned, failed ValidationErrors) {
+	for _, err := range e {
+		if slices.Contains(warnings, err.Check) {
+			warned = append(warned, err)
+		} else {
+			failed = append(failed, err)
+		}
+	}
+
+	return warned, failed
+}
+
+// Validate checks the structure of the state graph.
+// It returns ValidationErrors with all problems found, or nil if the graph is valid.
+func (sd *StateDetector[T]) Validate() error {
+	var errs ValidationErrors
+
+	names := make([]string, 0, len(sd.states))
+	for name := range sd.states {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	// unknown states
+	main, err := sd.getMainState()
+	if err != nil {
+		errs = append(errs, &ValidationError{Check: CheckUnknownState, Msg: err.Error()})
+	} else if _, ok := sd.states[main.String()]; !ok {
+		errs = append(errs, &ValidationError{
+			Check: CheckUnknownState, State: main, Msg: "main state is not registered",
+		})
+	}
+
+	for _, name := range names {
+		state := sd.states[name]
+
+		for _, status := range sortedKeys(state.Next) {
+			next := state.Next[status]
+			if sd.states[next.Name.String()] != next {
+				errs = append(errs, &ValidationError{
+					Check: CheckUnknownState,
+					State: state.Name,
+					Msg:   fmt.Sprintf("next state %s on %q is not registered", next.Name, status),
+				})
+			}
+		}
+	}
+
+	// unreachable states
+	if err == nil {
+		reachable := make(map[string]bool)
+		queue := []*State[T]{sd.states[main.String()]}
+		for len(queue) > 0 {
+			state := queue[0]
+			queue = queue[1:]
+
+			if state == nil || reachable[state.Name.String()] {
+				continue
+			}
+			reachable[state.Name.String()] = true
+
+			for _, next := range state.Next {
+				queue = append(queue, next)
+			}
+		}
+
+		for _, name := range names {
+			if !reachable[name] {
+				errs = append(errs, &ValidationError{
+					Check: CheckUnreachableState,
+					State: sd.states[name].Name,
+					Msg:   fmt.Sprintf("state is not reachable from the main state %s", main),
+				})
+			}
+		}
+	}
+
+	// transition cycles
+	for _, cycle := range sd.transitionCycles(names) {
+		cycleNames := make([]string, 0, len(cycle))
+		for _, state := range cycle {
+			cycleNames = append(cycleNames, state.Name.String())
+		}
+
+		errs = append(errs, &ValidationError{
+			Check: CheckTransitionCycle,
+			State: cycle[0].Name,
+			Msg:   "states form a cycle without wait event state: " + strings.Join(cycleNames, ", "),
+		})
+	}
+
+	// dead ends
+	for _, name := range names {
+		state := sd.states[name]
+		if len(state.Next) == 0 && !state.Terminal {
+			errs = append(errs, &ValidationError{
+				Check: CheckDeadEnd,
+				State: state.Name,
+				Msg:   "state has no next states and is not terminal",
+			})
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return errs
+}
+
+// transitionCycles returns the strongly connected components of the graph of StateTypeTransition states
+// which contain a cycle, the states of each component are sorted by name
+func (sd *StateDetector[T]) transitionCycles(names []string) [][]*State[T] {
+	var (
+		index   = make(map[*State[T]]int)
+		lowLink = make(map[*State[T]]int)
+		onStack = make(map[*State[T]]bool)
+		stack   []*State[T]
+		cycles  [][]*State[T]
+		strong  func(state *State[T])
+	)
+
+	// Tarjan's algorithm
+	strong = func(state *State[T]) {
+		index[state] = len(index)
+		lowLink[state] = index[state]
+		stack = append(stack, state)
+		onStack[state] = true
+
+		selfLoop := false
+		for _, status := range sortedKeys(state.Next) {
+			next := state.Next[status]
+			if next.StateType != StateTypeTransition {
+				continue
+			}
+
+			if next == state {
+				selfLoop = true
+			}
+
+			if _, ok := index[next]; !ok {
+				strong(next)
+				lowLink[state] = min(lowLink[state], lowLink[next])
+			} else if onStack[next] {
+				lowLink[state] = min(lowLink[state], index[next])
+			}
+		}
+
+		if lowLink[state] != index[state] {
+			return
+		}
+
+		var component []*State[T]
+		for {
+			s := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[s] = false
+			component = append(component, s)
+
+			if s == state {
+				break
+			}
+		}
+
+		if len(component) > 1 || selfLoop {
+			slices.SortFunc(component, func(a, b *State[T]) int {
+				return strings.Compare(a.Name.String(), b.Name.String())
+			})
+			cycles = append(cycles, component)
+		}
+	}
+
+	for _, name := range names {
+		state := sd.states[name]
+		if _, ok := index[state]; !ok && state.StateType == StateTypeTransition {
+			strong(state)
+		}
+	}
+
+	return cycles
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	return keys
+}
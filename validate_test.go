@@ -0,0 +1,83 @@
+package event_fsm
+
+import (
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestValidate(t *testing.T) {
+	sd := NewStateDetector[*eventData]()
+
+	firstCheck := sd.NewState(StateFirstCheck, &stateFirstCheck{}, StateTypeTransition)
+	add3 := sd.NewState(StateAdd3, &stateAdd3{}, StateTypeTransition)
+	manualAdd := sd.NewState(StateManualAdd, &stateManualAdd{}, StateTypeWaitEvent)
+	sd.NewState(StateRemove2, &stateRemove2{}, StateTypeTransition).SetTerminal()
+	lastCheck := &State[*eventData]{Name: StateLastCheck, StateType: StateTypeTransition}
+	sd.SetMainState(StateFirstCheck)
+
+	firstCheck.SetNext(add3, NotEnough)
+	firstCheck.SetNext(manualAdd, ResultStatusOk)
+	firstCheck.SetNext(lastCheck, WrongNumber)
+	add3.SetNext(firstCheck, ResultStatusOk)
+
+	err := sd.Validate()
+
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected ValidationErrors, got %v", err)
+	}
+
+	expected := map[ValidationCheck]StateName{
+		CheckUnknownState:     StateFirstCheck,
+		CheckUnreachableState: StateRemove2,
+		CheckTransitionCycle:  StateAdd3,
+		CheckDeadEnd:          StateManualAdd,
+	}
+
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d problems, got %v", len(expected), err)
+	}
+
+	for _, e := range errs {
+		if expected[e.Check] != e.State {
+			t.Fatalf("unexpected problem: %v", e)
+		}
+	}
+
+	manualAdd.SetNext(firstCheck, ResultStatusOk)
+	if err = sd.Validate(); !errors.As(err, &errs) || len(errs) != len(expected)-1 {
+		t.Fatalf("expected dead end to be fixed, got %v", err)
+	}
+}
+
+func TestValidateInNewFSM(t *testing.T) {
+	sd := NewStateDetector[*eventData]()
+
+	add3 := sd.NewState(StateAdd3, &stateAdd3{}, StateTypeTransition)
+	manualAdd := sd.NewState(StateManualAdd, &stateManualAdd{}, StateTypeWaitEvent)
+	sd.SetMainState(StateAdd3)
+	add3.SetNext(manualAdd, ResultStatusOk)
+
+	// every problem fails by default
+	cfg := &Config[*eventData]{Logger: zap.NewNop(), StateDetector: sd, Store: NewMemoryStore()}
+	if _, err := NewFSM(cfg); !errors.As(err, new(ValidationErrors)) {
+		t.Fatalf("expected ValidationErrors of the dead end, got %v", err)
+	}
+
+	cfg.ValidationWarnings = []ValidationCheck{CheckDeadEnd}
+	if _, err := NewFSM(cfg); err != nil {
+		t.Fatalf("NewFSM: %v", err)
+	}
+
+	// the warning doesn't cover the other checks
+	lastCheck := sd.NewState(StateLastCheck, &stateLastCheck{}, StateTypeTransition)
+	add3.SetNext(lastCheck, NotEnough)
+	lastCheck.SetNext(add3, ResultStatusOk)
+
+	var errs ValidationErrors
+	if _, err := NewFSM(cfg); !errors.As(err, &errs) || len(errs) != 1 || errs[0].Check != CheckTransitionCycle {
+		t.Fatalf("expected ValidationErrors of the transition cycle, got %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package event_fsm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+var _ Publisher = (*WebhookPublisher)(nil)
+
+// WebhookPublisher is the Publisher which posts the outbox entries to the URL as JSON.
+// The ID of the entry is sent in the Idempotency-Key header, the response other than 2xx is an error.
+type WebhookPublisher struct {
+	client *http.Client
+
+	url    string
+	header http.Header
+}
+
+// NewWebhookPublisher creates a new WebhookPublisher.
+// header is added to every request, e.g. the authorization, optional.
+// http.DefaultClient is used if client is nil.
+func NewWebhookPublisher(url string, header http.Header, client *http.Client) *WebhookPublisher {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &WebhookPublisher{
+		client: client,
+		url:    url,
+		header: header,
+	}
+}
+
+func (p *WebhookPublisher) Publish(ctx context.Context, entry OutboxEntry) error {
+	body, err := json.Marshal(outboxEntryToDTO(entry))
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("http.NewRequestWithContext: %w", err)
+	}
+
+	for k, v := range p.header {
+		req.Header[k] = v
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", entry.ID)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("client.Do: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// the body is drained to reuse the connection
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}